@@ -0,0 +1,207 @@
+// Package config loads and validates the server's settings.
+//
+// Settings are read from, in increasing priority: defaults, an optional
+// YAML file, the .env file, environment variables and command line flags.
+// Every setting has an environment variable, such as SITE_URL, and a
+// matching flag, such as -site-url. The YAML file is config.yaml unless
+// -config or CONFIG_FILE names another; see config.example.yaml.
+package config
+
+import (
+	"time"
+)
+
+// Config is every setting the server uses
+type Config struct {
+	AppName     string `yaml:"app_name" env:"APP_NAME"`
+	ServerAddr  string `yaml:"server_addr" env:"SERVER_ADDR"`
+	SiteURL     string `yaml:"site_url" env:"SITE_URL"`
+	Development bool   `yaml:"development" env:"DEVELOPMENT"`
+
+	// SessionKey signs the session cookies
+	SessionKey          string `yaml:"session_key" env:"SESSION_KEY"`
+	SessionLifetimeDays int    `yaml:"session_lifetime_days" env:"SESSION_LIFETIME_DAYS"`
+	// TrashRetentionDays is how long deleted media is kept. 0 keeps it
+	// until the trash is emptied.
+	TrashRetentionDays int `yaml:"trash_retention_days" env:"TRASH_RETENTION_DAYS"`
+
+	Log      Log      `yaml:"log"`
+	Timeouts Timeouts `yaml:"timeouts"`
+	DB       DB       `yaml:"db"`
+	Mail     Mail     `yaml:"mail"`
+	OIDC     OIDC     `yaml:"oidc"`
+	Security Security `yaml:"security"`
+	Metrics  Metrics  `yaml:"metrics"`
+	Tracing  Tracing  `yaml:"tracing"`
+
+	// warnings are problems that do not stop the server starting
+	warnings []string
+}
+
+// Log configures the server log
+type Log struct {
+	// Format is text, json or logfmt
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// Level is debug, info, warn or error
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+// Timeouts limit how long the server spends on each request. They are
+// written as durations, such as 30s or 1h.
+type Timeouts struct {
+	// Read and Write limit reading a request and writing its response
+	Read  time.Duration `yaml:"read" env:"READ_TIMEOUT"`
+	Write time.Duration `yaml:"write" env:"WRITE_TIMEOUT"`
+	// Upload replaces Read and Write when a signed in user uploads or
+	// replaces a media file, which may take much longer
+	Upload time.Duration `yaml:"upload" env:"UPLOAD_TIMEOUT"`
+	// Idle closes keep-alive connections that are not in use
+	Idle time.Duration `yaml:"idle" env:"IDLE_TIMEOUT"`
+	// Drain is how long the server keeps accepting requests after it is
+	// asked to stop, while /readyz fails, so load balancers stop sending
+	// it traffic first. 0 stops accepting requests straight away.
+	Drain time.Duration `yaml:"drain" env:"DRAIN_DELAY"`
+	// Shutdown is how long in-flight requests may finish after the server
+	// is asked to stop
+	Shutdown time.Duration `yaml:"shutdown" env:"SHUTDOWN_TIMEOUT"`
+}
+
+// DB is the database connection
+type DB struct {
+	// Type is sqlite3 or mysql
+	Type       string `yaml:"type" env:"DB_TYPE"`
+	Connection string `yaml:"connection" env:"DB_CONNECTION"`
+}
+
+// Mail selects how email is sent. The smtp driver sends through the SMTP
+// server; the log driver writes messages to the log and, when Dir is set,
+// to files for development and tests.
+type Mail struct {
+	Driver       string `yaml:"driver" env:"MAIL_DRIVER"`
+	Dir          string `yaml:"dir" env:"MAIL_DIR"`
+	From         string `yaml:"from" env:"MAIL_FROM"`
+	SMTPHost     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     string `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD"`
+}
+
+// OIDC configures single sign-on, which is enabled when Issuer and
+// ClientID are set
+type OIDC struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"OIDC_CLIENT_SECRET"`
+	// RedirectURL defaults to SiteURL/login/oidc/callback
+	RedirectURL string `yaml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	// ProviderName labels the sign in button
+	ProviderName string `yaml:"provider_name" env:"OIDC_PROVIDER_NAME"`
+	// AllowedDomains are the email domains that may sign in. Empty allows
+	// any domain.
+	AllowedDomains []string `yaml:"allowed_domains" env:"OIDC_ALLOWED_DOMAINS"`
+	// RoleClaim names a claim, such as groups, whose values RoleMap maps
+	// to roles, such as ace-owners=owner
+	RoleClaim string            `yaml:"role_claim" env:"OIDC_ROLE_CLAIM"`
+	RoleMap   map[string]string `yaml:"role_map" env:"OIDC_ROLE_MAP"`
+	// DefaultRole is given to new users without a mapped role
+	DefaultRole string `yaml:"default_role" env:"OIDC_DEFAULT_ROLE"`
+}
+
+// Enabled reports whether single sign-on is configured
+func (o OIDC) Enabled() bool {
+	return o.Issuer != "" && o.ClientID != ""
+}
+
+// Security configures the security headers and the proxies in front of
+// the server
+type Security struct {
+	// EmbedOrigins may frame the public media and collection pages
+	EmbedOrigins []string `yaml:"embed_origins" env:"EMBED_ORIGINS"`
+	// HSTSMaxAge is sent in seconds when SiteURL is https. 0 disables it.
+	HSTSMaxAge   int    `yaml:"hsts_max_age" env:"HSTS_MAX_AGE"`
+	CSPReportURI string `yaml:"csp_report_uri" env:"CSP_REPORT_URI"`
+	// TrustedProxies are addresses or CIDR ranges, such as 10.0.0.0/8,
+	// whose X-Forwarded-For and X-Real-IP headers give the client's address
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+// Metrics configures the Prometheus endpoint at /metrics
+type Metrics struct {
+	// Token, when set, must be sent by scrapers as a bearer token
+	Token string `yaml:"token" env:"METRICS_TOKEN"`
+}
+
+// Tracing exports OpenTelemetry traces over OTLP/HTTP, which is enabled
+// when Endpoint is set
+type Tracing struct {
+	// Endpoint is the collector's URL, such as http://localhost:4318. The
+	// path defaults to /v1/traces.
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	// Headers are sent with each export, such as an API key
+	Headers     map[string]string `yaml:"headers" env:"TRACING_HEADERS"`
+	ServiceName string            `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+	// SampleRatio is the share of new traces that are recorded, from 0 to 1
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// Enabled reports whether traces are exported
+func (t Tracing) Enabled() bool {
+	return t.Endpoint != ""
+}
+
+// defaults returns the settings used when nothing else is given
+func defaults() *Config {
+	return &Config{
+		AppName:             "ACE Video",
+		ServerAddr:          ":8080",
+		SessionLifetimeDays: 14,
+		TrashRetentionDays:  30,
+		Log: Log{
+			Format: "text",
+			Level:  "info",
+		},
+		Timeouts: Timeouts{
+			Read:     30 * time.Second,
+			Write:    60 * time.Second,
+			Upload:   time.Hour,
+			Idle:     2 * time.Minute,
+			Drain:    5 * time.Second,
+			Shutdown: time.Minute,
+		},
+		DB: DB{
+			Type: "sqlite3",
+		},
+		Mail: Mail{
+			Driver:   "log",
+			SMTPPort: "587",
+		},
+		OIDC: OIDC{
+			ProviderName: "single sign-on",
+			DefaultRole:  "viewer",
+		},
+		Security: Security{
+			HSTSMaxAge: 365 * 24 * 60 * 60,
+		},
+		Tracing: Tracing{
+			ServiceName: "ace-video",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Warnings lists problems with the settings that do not stop the server
+// starting. They are returned rather than logged so that they are written
+// in the configured log format.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// SessionLifetime is how long a sign in lasts
+func (c *Config) SessionLifetime() time.Duration {
+	return time.Duration(c.SessionLifetimeDays) * 24 * time.Hour
+}
+
+// TrashRetention is how long deleted media is kept, or 0 to keep it
+func (c *Config) TrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the settings for the command line arguments, which exclude
+// the program name, and validates them. It returns the arguments left
+// after the flags, such as a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	c := defaults()
+
+	fs := flag.NewFlagSet("ace-video", flag.ContinueOnError)
+	file := fs.String("config", "", "YAML config file (default config.yaml if present, or CONFIG_FILE)")
+	envFile := fs.String("env-file", ".env", ".env file to load")
+	values := map[string]*string{}
+	for _, f := range fields(c) {
+		name := flagName(f.env)
+		values[name] = fs.String(name, "", "sets "+f.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// The .env file only fills in variables that are not already set
+	if err := godotenv.Load(*envFile); err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("reading %s: %w", *envFile, err)
+	}
+
+	path, required := *file, true
+	if path == "" {
+		path, required = os.Getenv("CONFIG_FILE"), true
+	}
+	if path == "" {
+		path, required = "config.yaml", false
+	}
+	if err := loadYAML(c, path, required); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	for _, f := range fields(c) {
+		// Empty variables, as in .env.template, leave the default
+		if value := os.Getenv(f.env); strings.TrimSpace(value) != "" {
+			if err := set(f.value, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		value, ok := values[fl.Name]
+		if !ok {
+			return
+		}
+		for _, f := range fields(c) {
+			if flagName(f.env) == fl.Name {
+				if err := set(f.value, *value); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", fl.Name, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// loadYAML reads the YAML file over the defaults. Unknown keys are errors
+// so that typos are not silently ignored.
+func loadYAML(c *Config, path string, required bool) error {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// field is a setting with an environment variable
+type field struct {
+	env   string
+	value reflect.Value
+}
+
+// fields lists the settings in c, including those in nested sections
+func fields(c *Config) []field {
+	var found []field
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if env := t.Field(i).Tag.Get("env"); env != "" {
+				found = append(found, field{env: env, value: v.Field(i)})
+			} else if v.Field(i).Kind() == reflect.Struct {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem())
+	return found
+}
+
+// flagName turns an environment variable into a flag, so SITE_URL is
+// -site-url
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// set parses the text into the setting. Durations are written 30s or 1h,
+// lists are separated by commas or spaces, and maps are written
+// key=value,key=value.
+func set(v reflect.Value, text string) error {
+	text = strings.TrimSpace(text)
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, such as 30s or 1h", text)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		if text == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", text)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		if text == "" {
+			return errors.New("a number is required")
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", text)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", text)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		list := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := map[string]string{}
+		for _, pair := range strings.Split(text, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q should be key=value", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Kind())
+	}
+	return nil
+}
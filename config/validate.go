@@ -0,0 +1,181 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// minSessionKeyLength is the shortest session key accepted. The key signs
+// cookies with HMAC-SHA256, so it should have at least 256 bits.
+const minSessionKeyLength = 32
+
+// validate checks the settings and fills in those derived from others. All
+// problems are reported together.
+func (c *Config) validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.AppName == "" {
+		fail("APP_NAME must not be empty")
+	}
+	switch c.Log.Format {
+	case "text", "json", "logfmt":
+	default:
+		fail("LOG_FORMAT must be text, json or logfmt, not %q", c.Log.Format)
+	}
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		fail("LOG_LEVEL must be debug, info, warn or error, not %q", c.Log.Level)
+	}
+	if c.ServerAddr == "" {
+		fail("SERVER_ADDR must not be empty")
+	}
+
+	if c.SiteURL == "" && c.Development && strings.HasPrefix(c.ServerAddr, ":") {
+		c.SiteURL = "http://localhost" + c.ServerAddr
+	}
+	site, err := url.Parse(c.SiteURL)
+	if c.SiteURL == "" {
+		fail("SITE_URL is required, such as https://ace.example.com")
+	} else if err != nil || (site.Scheme != "http" && site.Scheme != "https") || site.Host == "" {
+		fail("SITE_URL must be an absolute http or https URL, not %q", c.SiteURL)
+	} else {
+		c.SiteURL = strings.TrimSuffix(c.SiteURL, "/")
+	}
+
+	switch {
+	case len(c.SessionKey) >= minSessionKeyLength:
+	case c.SessionKey == "" && c.Development:
+		// Sessions will not survive a restart, which is fine in development
+		key := make([]byte, minSessionKeyLength)
+		if _, err := rand.Read(key); err != nil {
+			fail("generating SESSION_KEY: %v", err)
+		}
+		c.SessionKey = base64.StdEncoding.EncodeToString(key)
+		c.warnings = append(c.warnings, "SESSION_KEY is not set, so a temporary key is being used. Everyone will be logged out when the server restarts.")
+	case c.SessionKey == "":
+		fail("SESSION_KEY is required. Generate one with: openssl rand -base64 32")
+	default:
+		fail("SESSION_KEY must be at least %d characters. Generate one with: openssl rand -base64 32", minSessionKeyLength)
+	}
+	if c.SessionLifetimeDays <= 0 {
+		fail("SESSION_LIFETIME_DAYS must be at least 1")
+	}
+	if c.TrashRetentionDays < 0 {
+		fail("TRASH_RETENTION_DAYS must not be negative")
+	}
+
+	timeouts := []struct {
+		env   string
+		value time.Duration
+	}{
+		{"READ_TIMEOUT", c.Timeouts.Read},
+		{"WRITE_TIMEOUT", c.Timeouts.Write},
+		{"UPLOAD_TIMEOUT", c.Timeouts.Upload},
+		{"IDLE_TIMEOUT", c.Timeouts.Idle},
+		{"SHUTDOWN_TIMEOUT", c.Timeouts.Shutdown},
+	}
+	for _, t := range timeouts {
+		if t.value <= 0 {
+			fail("%s must be a positive duration, such as 30s", t.env)
+		}
+	}
+	if c.Timeouts.Drain < 0 {
+		fail("DRAIN_DELAY must not be negative")
+	}
+	if c.Timeouts.Upload < c.Timeouts.Read {
+		fail("UPLOAD_TIMEOUT must not be shorter than READ_TIMEOUT")
+	}
+
+	switch c.DB.Type {
+	case "sqlite3", "mysql":
+	default:
+		fail("DB_TYPE must be sqlite3 or mysql, not %q", c.DB.Type)
+	}
+	if c.DB.Connection == "" {
+		fail("DB_CONNECTION is required, such as ./ace-video.db")
+	}
+
+	switch c.Mail.Driver {
+	case "log":
+	case "smtp":
+		if c.Mail.SMTPHost == "" || c.Mail.From == "" {
+			fail("SMTP_HOST and MAIL_FROM are required for the smtp mail driver")
+		}
+	default:
+		fail("MAIL_DRIVER must be smtp or log, not %q", c.Mail.Driver)
+	}
+
+	if c.OIDC.Issuer != "" || c.OIDC.ClientID != "" {
+		if !c.OIDC.Enabled() {
+			fail("OIDC_ISSUER and OIDC_CLIENT_ID are both required for single sign-on")
+		}
+		c.OIDC.Issuer = strings.TrimSuffix(c.OIDC.Issuer, "/")
+		if c.OIDC.RedirectURL == "" && c.SiteURL != "" {
+			c.OIDC.RedirectURL = c.SiteURL + "/login/oidc/callback"
+		}
+		for i, domain := range c.OIDC.AllowedDomains {
+			c.OIDC.AllowedDomains[i] = strings.ToLower(strings.TrimPrefix(domain, "@"))
+		}
+		if len(c.OIDC.RoleMap) > 0 && c.OIDC.RoleClaim == "" {
+			fail("OIDC_ROLE_CLAIM is required when OIDC_ROLE_MAP is set")
+		}
+		for value, role := range c.OIDC.RoleMap {
+			if !validRole(role) {
+				fail("OIDC_ROLE_MAP maps %q to unknown role %q", value, role)
+			}
+		}
+		if !validRole(c.OIDC.DefaultRole) {
+			fail("OIDC_DEFAULT_ROLE must be viewer, editor or owner, not %q", c.OIDC.DefaultRole)
+		}
+	}
+
+	for i, origin := range c.Security.EmbedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			fail("EMBED_ORIGINS entry %q must be an origin, such as https://lms.example.com", origin)
+			continue
+		}
+		c.Security.EmbedOrigins[i] = u.Scheme + "://" + u.Host
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		fail("HSTS_MAX_AGE must not be negative")
+	}
+	for i, proxy := range c.Security.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			c.Security.TrustedProxies[i] = netip.PrefixFrom(addr, addr.BitLen()).String()
+		} else if _, err := netip.ParsePrefix(proxy); err != nil {
+			fail("TRUSTED_PROXIES entry %q must be an address or CIDR range, such as 10.0.0.0/8", proxy)
+		}
+	}
+
+	if c.Tracing.Enabled() {
+		u, err := url.Parse(c.Tracing.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("TRACING_ENDPOINT must be an http or https URL, such as http://localhost:4318")
+		}
+		if c.Tracing.ServiceName == "" {
+			fail("TRACING_SERVICE_NAME must not be empty")
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			fail("TRACING_SAMPLE_RATIO must be from 0 to 1")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validRole reports whether the role exists. The roles are defined in the
+// models package, which depends on this one.
+func validRole(role string) bool {
+	return role == "viewer" || role == "editor" || role == "owner"
+}
@@ -23,9 +23,9 @@ type FileSystem interface {
 	Open(name string) (http.File, error)
 }
 
-// Myfs is the accessible directory
+// Myfs is the accessible directory, such as an http.Dir
 type Myfs struct {
-	Dir http.Dir
+	Dir http.FileSystem
 }
 
 // Open returns the file if available.
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminAccountHandler shows the password form and the user's active sessions
+func adminAccountHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Account"
+
+	data["messages"] = flash.Get(w, r)
+
+	user, _ := models.UserFromContext(r.Context())
+	current, _ := models.SessionFromContext(r.Context())
+	sessions, err := models.FindUserSessions(r.Context(), user.ID)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding sessions", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["sessions"] = sessions
+	}
+	data["current"] = current
+
+	render(w, data, true, "account")
+}
+
+// adminPasswordHandler changes the user's password and signs them out
+// everywhere else
+func adminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, _ := models.UserFromContext(r.Context())
+	current, _ := models.SessionFromContext(r.Context())
+	password := r.FormValue("password")
+
+	if !user.CheckPassword(r.FormValue("current_password")) {
+		flash.Message{
+			Title:   "Error",
+			Message: "Your current password is incorrect",
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else if password == "" || password != r.FormValue("confirm_password") {
+		flash.Message{
+			Title:   "Error",
+			Message: "The new passwords do not match",
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else if err := models.ValidatePassword(password, user.Email); err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: err.Error(),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else if err := user.ChangePassword(r.Context(), password, current.ID); err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error changing password", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Password changed. Your other sessions have been signed out.",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+}
+
+// adminRevokeSessionHandler signs out one of the user's other sessions
+func adminRevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, _ := models.UserFromContext(r.Context())
+	session, err := models.FindUserSessionByID(r.Context(), user.ID, chi.URLParam(r, "id"))
+	if err == nil {
+		err = session.Revoke(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error signing out session", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Session signed out",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+}
+
+// adminRevokeOtherSessionsHandler signs the user out everywhere else
+func adminRevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, _ := models.UserFromContext(r.Context())
+	current, _ := models.SessionFromContext(r.Context())
+	err := models.RevokeOtherSessions(r.Context(), user.ID, current.ID)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error signing out sessions", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "All other sessions have been signed out",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+}
+
+// adminTOTPHandler shows two factor authentication settings, starting
+// enrolment if it is not yet enabled
+func adminTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	renderTOTPPage(w, r, nil)
+}
+
+// adminTOTPEnableHandler confirms enrolment with a code from the app and
+// shows the recovery codes
+func adminTOTPEnableHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := models.UserFromContext(r.Context())
+	codes, err := user.EnableTOTP(r.Context(), r.FormValue("code"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error enabling two factor authentication", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	flash.Message{
+		Title:   "Success",
+		Message: "Two factor authentication is enabled",
+		Style:   flash.Success,
+	}.Save(w, r)
+	renderTOTPPage(w, r, codes)
+}
+
+// adminTOTPRecoveryHandler replaces the recovery codes
+func adminTOTPRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := models.UserFromContext(r.Context())
+	if !user.CheckPassword(r.FormValue("password")) {
+		flash.Message{
+			Title:   "Error",
+			Message: "Your password is incorrect",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	codes, err := user.RegenerateRecoveryCodes(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error creating recovery codes", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/account/2fa", http.StatusSeeOther)
+		return
+	}
+	renderTOTPPage(w, r, codes)
+}
+
+// adminTOTPDisableHandler turns off two factor authentication unless an
+// owner requires it
+func adminTOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, _ := models.UserFromContext(r.Context())
+	var err error
+	if user.TOTPRequired {
+		err = models.NewUserError("it is required for your account")
+	} else if !user.CheckPassword(r.FormValue("password")) {
+		err = models.NewUserError("your password is incorrect")
+	} else {
+		err = user.DisableTOTP(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error disabling two factor authentication", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Two factor authentication is disabled",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/account/2fa", http.StatusSeeOther)
+}
+
+// renderTOTPPage shows the two factor settings, along with new recovery
+// codes when there are any. Codes are only ever shown once.
+func renderTOTPPage(w http.ResponseWriter, r *http.Request, codes []string) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Two factor authentication"
+
+	user, _ := models.UserFromContext(r.Context())
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		err := user.StartTOTPEnrolment(r.Context())
+		if err != nil {
+			flash.Message{
+				Title:   "Error",
+				Message: errorMessage(r, "Error setting up two factor authentication", err),
+				Style:   flash.Error,
+			}.Save(w, r)
+		}
+	}
+	if user.TOTPEnabled {
+		remaining, err := user.RemainingRecoveryCodes(r.Context())
+		if err != nil {
+			flash.Message{
+				Title:   "Error",
+				Message: errorMessage(r, "Error counting recovery codes", err),
+				Style:   flash.Error,
+			}.Save(w, r)
+		}
+		data["remaining"] = remaining
+	}
+
+	data["codes"] = codes
+	data["messages"] = flash.Get(w, r)
+	render(w, data, true, "account_2fa")
+}
+
+// adminAPITokensHandler lists the user's API tokens
+func adminAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	renderAPITokensPage(w, r, "")
+}
+
+// adminAPITokenCreateHandler creates an API token and shows it once
+func adminAPITokenCreateHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := models.UserFromContext(r.Context())
+	r.ParseForm()
+	var scopes []models.APIScope
+	for _, scope := range r.Form["scopes"] {
+		scopes = append(scopes, models.APIScope(scope))
+	}
+
+	token, err := models.NewAPIToken(r.Context(), user.ID, r.FormValue("name"), scopes)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error creating token", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/account/tokens", http.StatusSeeOther)
+		return
+	}
+	renderAPITokensPage(w, r, token)
+}
+
+// adminAPITokenRevokeHandler deletes one of the user's API tokens
+func adminAPITokenRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, _ := models.UserFromContext(r.Context())
+	token, err := models.FindUserAPITokenByID(r.Context(), user.ID, chi.URLParam(r, "id"))
+	if err == nil {
+		err = token.Revoke(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error revoking token", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: token.Name + " has been revoked",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/account/tokens", http.StatusSeeOther)
+}
+
+// renderAPITokensPage lists the user's tokens, along with a new token when
+// one has just been created
+func renderAPITokensPage(w http.ResponseWriter, r *http.Request, token string) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "API tokens"
+
+	user, _ := models.UserFromContext(r.Context())
+	tokens, err := models.FindUserAPITokens(r.Context(), user.ID)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding tokens", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["tokens"] = tokens
+	}
+
+	data["token"] = token
+	data["scopes"] = models.APIScopes
+	data["messages"] = flash.Get(w, r)
+	render(w, data, true, "account_tokens")
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminCardsHandler shows printable QR cards for a media item and its chapters
+func adminCardsHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	media, err := models.FindMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	data["title"] = "Cards"
+	data["media"] = media
+	render(w, data, true, "cards")
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminMediaEditHandler shows a single media item with its chapters
+func adminMediaEditHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	media, err := models.FindMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	versions, err := models.FindMediaVersions(r.Context(), media.ID)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding previous versions", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	}
+
+	teams, err := userTeams(r)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding teams", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	}
+	// Keep the current team selectable even if the user is not a member
+	if media.Team != nil && !teams.Contains(media.TeamID) {
+		teams = append(teams, media.Team)
+	}
+
+	data["title"] = media.Title
+	data["media"] = media
+	data["library"] = models.Library{media}
+	data["versions"] = versions
+	data["teams"] = teams
+	data["messages"] = flash.Get(w, r)
+	render(w, data, true, "media_edit")
+}
+
+// adminChapterCreateHandler adds a chapter to a media item
+func adminChapterCreateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	chapter := &models.Chapter{MediaID: media.ID}
+	if !bindChapter(w, r, chapter) {
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	err = chapter.Save(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error saving chapter", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Chapter added successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminChapterUpdateHandler updates a chapter of a media item
+func adminChapterUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	chapter, err := models.FindChapterByID(r.Context(), media.ID, chi.URLParam(r, "chapter"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding chapter", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if !bindChapter(w, r, chapter) {
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	err = chapter.Save(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating chapter", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Chapter updated successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminChapterDeleteHandler removes a chapter from a media item
+func adminChapterDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	chapter, err := models.FindChapterByID(r.Context(), media.ID, chi.URLParam(r, "chapter"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding chapter", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	err = chapter.Delete(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error deleting chapter", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Chapter deleted successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// bindChapter copies the chapter form values onto the chapter.
+// It flashes an error and returns false if the values are invalid.
+func bindChapter(w http.ResponseWriter, r *http.Request, chapter *models.Chapter) bool {
+	start, err := helpers.ParseTimestamp(r.FormValue("start"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: "Invalid start time. Use seconds or mm:ss, e.g. 4:30",
+			Style:   flash.Error,
+		}.Save(w, r)
+		return false
+	}
+
+	if r.FormValue("title") == "" {
+		flash.Message{
+			Title:   "Error",
+			Message: "Chapters need a title",
+			Style:   flash.Error,
+		}.Save(w, r)
+		return false
+	}
+
+	chapter.Start = start
+	chapter.Title = r.FormValue("title")
+	chapter.Description = r.FormValue("description")
+	return true
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminCollectionsHandler lists the collections
+func adminCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Collections"
+
+	data["messages"] = flash.Get(w, r)
+
+	collections, err := models.FindAllCollections(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding collections", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["collections"] = collections
+	}
+
+	render(w, data, true, "collections_index")
+}
+
+// adminCollectionCreateHandler creates a new collection
+func adminCollectionCreateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	if r.FormValue("title") == "" {
+		flash.Message{
+			Title:   "Error",
+			Message: "Collections need a title",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+		return
+	}
+
+	collection := &models.Collection{
+		Title:       r.FormValue("title"),
+		Description: r.FormValue("description"),
+	}
+	err := collection.Save(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error creating collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/collections/"+collection.ID, http.StatusSeeOther)
+}
+
+// adminCollectionHandler shows a collection and its media
+func adminCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	collection, err := models.FindCollectionByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+		return
+	}
+
+	media, err := models.FindAllMedia(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	}
+
+	data["title"] = collection.Title
+	data["collection"] = collection
+	data["media"] = media
+	data["messages"] = flash.Get(w, r)
+	render(w, data, true, "collections_edit")
+}
+
+// adminCollectionUpdateHandler updates the details of a collection
+func adminCollectionUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/collections/" + id
+
+	collection, err := models.FindCollectionByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+		return
+	}
+
+	collection.Title = r.FormValue("title")
+	collection.Description = r.FormValue("description")
+	collection.CoverMediaID = r.FormValue("cover")
+
+	err = collection.Save(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Collection updated successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminCollectionDeleteHandler deletes a collection, but not its media
+func adminCollectionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	collection, err := models.FindCollectionByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err == nil {
+		err = collection.Delete(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error deleting collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Collection deleted successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+}
+
+// adminCollectionAddHandler adds a media item to the end of a collection
+func adminCollectionAddHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/collections/" + id
+
+	collection, err := models.FindCollectionByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+		return
+	}
+
+	media, err := models.FindMediaByID(r.Context(), r.FormValue("media"))
+	if err == nil {
+		err = collection.AddMedia(r.Context(), media.ID)
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error adding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminCollectionRemoveHandler removes a media item from a collection
+func adminCollectionRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/collections/" + id
+
+	collection, err := models.FindCollectionByID(r.Context(), id)
+	if err == nil {
+		err = collection.RemoveMedia(r.Context(), chi.URLParam(r, "media"))
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error removing media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminCollectionOrderHandler saves the order of a collection.
+// The form lists each media ID in its new order.
+func adminCollectionOrderHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := models.FindCollectionByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err != nil {
+		apiError(w, r, "Error finding collection", err)
+		return
+	}
+
+	r.ParseForm()
+	err = collection.Reorder(r.Context(), r.Form["media"])
+	if err != nil {
+		apiError(w, r, "Error saving order", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminCollectionCardHandler shows a printable QR card for a collection
+func adminCollectionCardHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	collection, err := models.FindCollectionByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding collection", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/collections", http.StatusSeeOther)
+		return
+	}
+
+	data["title"] = "Cards"
+	data["collection"] = collection
+	render(w, data, true, "collections_card")
+}
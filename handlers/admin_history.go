@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminMediaHistoryHandler shows the audit trail of a media item
+func adminMediaHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	id := chi.URLParam(r, "uuid")
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		// Deleted media still has a history worth seeing
+		media, err = models.FindDeletedMediaByID(r.Context(), id)
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	entries, err := models.FindAuditEntries(r.Context(), media.ID)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding history", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	}
+
+	data["title"] = "History"
+	data["media"] = media
+	data["entries"] = entries
+	data["messages"] = flash.Get(w, r)
+	render(w, data, true, "media_history")
+}
+
+// adminMediaRevertHandler undoes a change from the audit trail
+func adminMediaRevertHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id + "/history"
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		media, err = models.FindDeletedMediaByID(r.Context(), id)
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	entry, err := models.FindAuditEntryByID(r.Context(), media.ID, chi.URLParam(r, "entry"))
+	if err == nil {
+		err = entry.Revert(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error reverting change", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Change reverted successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
@@ -26,20 +26,20 @@ func adminJSONPreviewHandler(w http.ResponseWriter, r *http.Request) {
 
 	options, err := parseJSONOptions(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiError(w, r, "Invalid options", err)
 		return
 	}
 
 	media, err := models.FindMatchingMedia(r.Context(), options)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiError(w, r, "Error finding media", err)
 		return
 	}
 	exportMedia := make(models.Library, len(media))
 	copy(exportMedia, media)
 	jsonMedia, err := exportMedia.MarshalJSON()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiError(w, r, "Error encoding media", err)
 		return
 	}
 
@@ -1,16 +1,27 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
+	"github.com/charmbracelet/log"
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/metrics"
 	"github.com/nathanhollows/ace-video/models"
+	"github.com/nathanhollows/ace-video/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // adminMediaHandler shows the media files
@@ -25,13 +36,24 @@ func adminMediaHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		flash.Message{
 			Title:   "Error",
-			Message: "Error finding media: " + err.Error(),
+			Message: errorMessage(r, "Error finding media", err),
 			Style:   flash.Error,
 		}.Save(w, r)
 	} else {
 		data["media"] = media
 	}
 
+	teams, err := userTeams(r)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding teams", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["teams"] = teams
+	}
+
 	// Render the template
 	render(w, data, true, "media_index")
 }
@@ -47,7 +69,7 @@ func adminMediaUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		flash.Message{
 			Title:   "Error",
-			Message: "Error finding media: " + err.Error(),
+			Message: errorMessage(r, "Error finding media", err),
 			Style:   flash.Error,
 		}.Save(w, r)
 		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
@@ -62,7 +84,7 @@ func adminMediaUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		flash.Message{
 			Title:   "Error",
-			Message: "Error updating media: " + err.Error(),
+			Message: errorMessage(r, "Error updating media", err),
 			Style:   flash.Error,
 		}.Save(w, r)
 	} else {
@@ -89,12 +111,19 @@ func adminMediaUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := readUpload(r); err != nil {
+		uploadError(w, r, "", err)
 		return
 	}
 
 	files := r.MultipartForm.File["file"]
+	uploaded := models.Library{}
+
+	teamID, err := uploadTeam(r)
+	if err != nil {
+		uploadError(w, r, "", err)
+		return
+	}
 
 	for _, fileHeader := range files {
 
@@ -102,138 +131,59 @@ func adminMediaUploadHandler(w http.ResponseWriter, r *http.Request) {
 		var maxUploadSize int64 = 1024 * 1024 * 1024 // 1GB
 		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 		if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "File is too large. There is a 1GB limit.",
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		file, err := fileHeader.Open()
-		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error uploading file: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		defer file.Close()
-
-		// Check the file type
-		// Only allow images and videos
-		regex, err := regexp.Compile("image/.*|video/.*")
-		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error checking file type: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		buff := make([]byte, 512)
-		_, err = file.Read(buff)
-		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error reading file: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		filetype := http.DetectContentType(buff)
-		if !regex.MatchString(filetype) {
-			flash.Message{
-				Title:   "Error",
-				Message: "Invalid file type. Only images and videos are allowed.",
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		_, err = file.Seek(0, io.SeekStart)
-		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error reading file: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		// Create the uploads directory if it doesn't exist
-		err = os.MkdirAll("assets/media", 0755)
-		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error creating uploads directory: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+			metrics.UploadFailures.WithLabelValues(metrics.UploadTooLarge).Inc()
+			uploadError(w, r, "", errUploadTooLarge)
 			return
 		}
 
 		// Generate a new file name with a UUID
 		id := uuid.New().String()
-		extension := filepath.Ext(fileHeader.Filename)
-		newFileName := id + extension
-
-		// Create the file
-		newFile, err := os.Create("assets/media/" + newFileName)
+		newFileName, mimeType, err := storeUpload(r.Context(), fileHeader, id)
 		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error creating file: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
-			return
-		}
-
-		defer newFile.Close()
-
-		// Copy the file
-		_, err = io.Copy(newFile, file)
-		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error copying file: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+			uploadError(w, r, "Error uploading file", err)
 			return
 		}
 
 		// Save the media to the database
 		media := &models.Media{
 			ID:       id,
+			TeamID:   teamID,
 			Title:    fileHeader.Filename,
 			FileName: fileHeader.Filename,
-			MimeType: fileHeader.Header.Get("Content-Type"),
+			MimeType: mimeType,
 			FilePath: "/media/" + newFileName,
 		}
+
+		// Read EXIF and strip location and device metadata from images
+		if media.Type() == "image" {
+			err = media.ProcessImage(r.Context())
+			if err != nil {
+				metrics.UploadFailures.WithLabelValues(metrics.UploadError).Inc()
+				uploadError(w, r, "Error processing image", err)
+				os.Remove(media.StoragePath())
+				os.Remove(media.OriginalPath())
+				return
+			}
+		}
+
 		err = media.Save(r.Context())
 		if err != nil {
-			flash.Message{
-				Title:   "Error",
-				Message: "Error saving media: " + err.Error(),
-				Style:   flash.Error,
-			}.Save(w, r)
-			http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+			metrics.UploadFailures.WithLabelValues(metrics.UploadError).Inc()
+			uploadError(w, r, "Error saving media", err)
 			// Remove the file
-			os.Remove("assets/media/" + newFileName)
+			os.Remove(filepath.Join(mediaDir, newFileName))
+			os.Remove(media.OriginalPath())
 			return
 		}
+		uploaded = append(uploaded, media)
+	}
+
+	// Scripts get the new media so they can go on to tag it
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(uploaded)
+		return
 	}
 
 	flash.Message{
@@ -244,3 +194,367 @@ func adminMediaUploadHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
 
 }
+
+// adminMediaReplaceHandler swaps the file of a media item for a new upload.
+// The media keeps its ID, so printed QR codes keep working.
+func adminMediaReplaceHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	// Check the file size
+	var maxUploadSize int64 = 1024 * 1024 * 1024 // 1GB
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := readUpload(r); err != nil {
+		metrics.UploadFailures.WithLabelValues(metrics.UploadTooLarge).Inc()
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	_, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: "Please choose a file to upload",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	newFileName, mimeType, err := storeUpload(r.Context(), fileHeader, uuid.New().String())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error uploading file", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	err = media.ReplaceFile(r.Context(),
+		fileHeader.Filename,
+		mimeType,
+		"/media/"+newFileName,
+	)
+	if err != nil {
+		metrics.UploadFailures.WithLabelValues(metrics.UploadError).Inc()
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error replacing file", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "File replaced successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminMediaRollbackHandler restores a previous version of the file
+func adminMediaRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	version, err := models.FindMediaVersionByID(r.Context(), id, chi.URLParam(r, "version"))
+	if err == nil {
+		err = media.Rollback(r.Context(), version)
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error rolling back", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Rolled back to " + version.FileName,
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// readUpload reads the multipart form, keeping large files on disk. Slow
+// clients spend most of an upload here, so it has its own span.
+func readUpload(r *http.Request) (err error) {
+	_, span := tracing.Span(r.Context(), "upload.read_body",
+		attribute.Int64("http.request.body.size", r.ContentLength),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	extendUploadDeadlines(r)
+	// A body that cannot be read was cut short or sent badly by the client
+	err = r.ParseMultipartForm(32 << 20)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errUploadTooLarge
+	} else if err != nil {
+		log.FromContext(r.Context()).Warn("Error reading upload", "err", err)
+		return models.NewUserError("The upload could not be read. Please try again.")
+	}
+	return nil
+}
+
+// uploadError tells a script why the upload failed with problem details,
+// or the browser with a flash message on the media page
+func uploadError(w http.ResponseWriter, r *http.Request, prefix string, err error) {
+	if isAPIRequest(r) {
+		apiError(w, r, prefix, err)
+		return
+	}
+	flash.Message{
+		Title:   "Error",
+		Message: errorMessage(r, prefix, err),
+		Style:   flash.Error,
+	}.Save(w, r)
+	http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+}
+
+var errInvalidFileType = models.NewUserError("only videos and JPEG, PNG, GIF, WebP or BMP images are allowed")
+
+var errUploadTooLarge = models.NewUserError("File is too large. There is a 1GB limit.")
+
+// storeUpload checks that an uploaded file is an image or video and saves it
+// to assets/media, named with the given ID. It returns the new file name
+// and the MIME type. Images get the type read from their content, so one
+// sent as a video still has its metadata stripped.
+func storeUpload(ctx context.Context, fileHeader *multipart.FileHeader, id string) (name, mimeType string, err error) {
+	_, span := tracing.Span(ctx, "storage.store_upload",
+		attribute.String("file.name", fileHeader.Filename),
+		attribute.Int64("file.size", fileHeader.Size),
+	)
+	defer func() {
+		tracing.End(span, err)
+		if errors.Is(err, errInvalidFileType) {
+			metrics.UploadFailures.WithLabelValues(metrics.UploadRejected).Inc()
+		} else if err != nil {
+			metrics.UploadFailures.WithLabelValues(metrics.UploadError).Inc()
+		}
+	}()
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", "", fmt.Errorf("opening upload: %w", err)
+	}
+	defer file.Close()
+
+	// Check the file type
+	// Only allow images and videos
+	regex, err := regexp.Compile("image/.*|video/.*")
+	if err != nil {
+		return "", "", fmt.Errorf("checking file type: %w", err)
+	}
+
+	buff := make([]byte, 512)
+	_, err = file.Read(buff)
+	if err != nil {
+		return "", "", fmt.Errorf("reading upload: %w", err)
+	}
+
+	filetype := http.DetectContentType(buff)
+	if !regex.MatchString(filetype) {
+		return "", "", errInvalidFileType
+	}
+	mimeType = fileHeader.Header.Get("Content-Type")
+	if strings.HasPrefix(filetype, "image/") {
+		// Only images whose metadata can be stripped are published
+		if !helpers.StrippableImage(filetype) {
+			return "", "", errInvalidFileType
+		}
+		mimeType = filetype
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", "", fmt.Errorf("reading upload: %w", err)
+	}
+
+	// Create the uploads directory if it doesn't exist
+	err = os.MkdirAll(mediaDir, 0755)
+	if err != nil {
+		return "", "", fmt.Errorf("creating uploads directory: %w", err)
+	}
+
+	extension := filepath.Ext(fileHeader.Filename)
+	newFileName := id + extension
+
+	// Create the file
+	newFile, err := os.Create(filepath.Join(mediaDir, newFileName))
+	if err != nil {
+		return "", "", fmt.Errorf("creating file: %w", err)
+	}
+	defer newFile.Close()
+
+	// Copy the file
+	n, err := io.Copy(newFile, file)
+	if err != nil {
+		os.Remove(filepath.Join(mediaDir, newFileName))
+		return "", "", fmt.Errorf("copying upload: %w", err)
+	}
+	metrics.UploadBytes.Add(float64(n))
+
+	return newFileName, mimeType, nil
+}
+
+// adminMediaMetadataHandler controls whether an image is published with its
+// location and device metadata
+func adminMediaMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil || media.Type() != "image" {
+		flash.Message{
+			Title:   "Error",
+			Message: "Only images have metadata to publish",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	media.KeepMetadata = r.FormValue("keep_metadata") == "on"
+	err = media.ProcessImage(r.Context())
+	if err == nil {
+		err = media.Save(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating metadata", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Metadata settings updated successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminMediaTeamHandler shares a media item with one of the user's teams,
+// or with everyone
+func adminMediaTeamHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	teamID, err := chosenTeam(r)
+	if err == nil {
+		media.TeamID = teamID
+		err = media.Save(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating team", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Sharing updated successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// userTeams finds the teams of the logged in user
+func userTeams(r *http.Request) (models.Teams, error) {
+	user, ok := models.UserFromContext(r.Context())
+	if !ok {
+		return models.Teams{}, nil
+	}
+	return models.FindUserTeams(r.Context(), user.ID)
+}
+
+// chosenTeam returns the team picked in the form. Users can only pick teams
+// they belong to. An empty ID shares the media with everyone.
+func chosenTeam(r *http.Request) (string, error) {
+	id := r.FormValue("team")
+	if id == "" {
+		return "", nil
+	}
+	teams, err := userTeams(r)
+	if err != nil {
+		return "", err
+	}
+	if !teams.Contains(id) {
+		return "", models.NewForbiddenError("You are not a member of that team")
+	}
+	return id, nil
+}
+
+// uploadTeam returns the team for new uploads. Uploads that do not pick a
+// team, such as those from the API, go to the user's team if they belong to
+// exactly one.
+func uploadTeam(r *http.Request) (string, error) {
+	if _, ok := r.MultipartForm.Value["team"]; ok {
+		return chosenTeam(r)
+	}
+	teams, err := userTeams(r)
+	if err != nil {
+		return "", err
+	}
+	if len(teams) == 1 {
+		return teams[0].ID, nil
+	}
+	return "", nil
+}
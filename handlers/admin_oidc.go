@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/models"
+	"github.com/nathanhollows/ace-video/oidc"
+	"github.com/nathanhollows/ace-video/sessions"
+)
+
+// oidcLoginLifetime is how long the user has to sign in with the provider
+const oidcLoginLifetime = 10 * time.Minute
+
+// adminOIDCLoginHandler sends the browser to the identity provider
+func adminOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, err := sessions.Get(r, "admin")
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error getting session", "err", err)
+		oidcLoginFailed(w, r, "An error occurred while trying to log in.")
+		return
+	}
+	login, err := oidc.NewLogin()
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error starting single sign-on", "err", err)
+		oidcLoginFailed(w, r, "An error occurred while trying to log in.")
+		return
+	}
+	url, err := oidc.AuthCodeURL(r.Context(), login)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error starting single sign-on", "err", err)
+		oidcLoginFailed(w, r, "Single sign-on is unavailable right now. Please try again later.")
+		return
+	}
+
+	session.Values["oidc_state"] = login.State
+	session.Values["oidc_nonce"] = login.Nonce
+	session.Values["oidc_verifier"] = login.Verifier
+	session.Values["oidc_at"] = time.Now().Unix()
+	session.Options.HttpOnly = true
+	// The provider redirects back with a top level GET, which Lax allows
+	session.Options.SameSite = http.SameSiteLaxMode
+	session.Save(r, w)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// adminOIDCCallbackHandler finishes signing in when the provider sends
+// the browser back
+func adminOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, err := sessions.Get(r, "admin")
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error getting session", "err", err)
+		oidcLoginFailed(w, r, "An error occurred while trying to log in.")
+		return
+	}
+	login, err := pendingOIDCLogin(r)
+	// Each sign in can only be completed once
+	for _, key := range []string{"oidc_state", "oidc_nonce", "oidc_verifier", "oidc_at"} {
+		delete(session.Values, key)
+	}
+	session.Save(r, w)
+	if err != nil {
+		oidcLoginFailed(w, r, err.Error())
+		return
+	}
+
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		log.FromContext(r.Context()).Warn("Single sign-on refused", "error", reason, "description", r.URL.Query().Get("error_description"))
+		oidcLoginFailed(w, r, "The sign in was cancelled or refused by the provider.")
+		return
+	}
+
+	identity, err := oidc.Exchange(r.Context(), r.URL.Query().Get("code"), login)
+	if errors.Is(err, oidc.ErrNotAllowed) {
+		log.FromContext(r.Context()).Warn("Single sign-on from a domain that is not allowed", "ip", r.RemoteAddr)
+		oidcLoginFailed(w, r, "Your email address is not allowed to sign in here.")
+		return
+	}
+	if errors.Is(err, oidc.ErrUnverified) {
+		oidcLoginFailed(w, r, "Please verify your email address with the provider before signing in.")
+		return
+	}
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error completing single sign-on", "err", err)
+		oidcLoginFailed(w, r, "We couldn't verify your sign in with the provider. Please try again.")
+		return
+	}
+
+	user, err := models.AuthenticateOIDC(r.Context(), identity)
+	if err != nil {
+		log.FromContext(r.Context()).Warn("Single sign-on rejected", "email", identity.Email, "err", err)
+		oidcLoginFailed(w, r, "Your account can't sign in. Please contact an administrator.")
+		return
+	}
+
+	completeLogin(w, r, session, user)
+}
+
+// pendingOIDCLogin finds the sign in that this browser started, and checks
+// that the callback belongs to it
+func pendingOIDCLogin(r *http.Request) (*oidc.Login, error) {
+	expired := errors.New("Your login has expired. Please log in again.")
+	session, err := sessions.Get(r, "admin")
+	if err != nil {
+		return nil, expired
+	}
+	login := &oidc.Login{}
+	login.State, _ = session.Values["oidc_state"].(string)
+	login.Nonce, _ = session.Values["oidc_nonce"].(string)
+	login.Verifier, _ = session.Values["oidc_verifier"].(string)
+	started, _ := session.Values["oidc_at"].(int64)
+	if login.State == "" || time.Since(time.Unix(started, 0)) > oidcLoginLifetime {
+		return nil, expired
+	}
+	state := r.URL.Query().Get("state")
+	if subtle.ConstantTimeCompare([]byte(state), []byte(login.State)) != 1 {
+		return nil, expired
+	}
+	return login, nil
+}
+
+// oidcLoginFailed sends the user back to the login page with a message
+func oidcLoginFailed(w http.ResponseWriter, r *http.Request, message string) {
+	flash.Message{
+		Title:   "Error",
+		Message: message,
+		Style:   flash.Error,
+	}.Save(w, r)
+	http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+}
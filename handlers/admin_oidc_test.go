@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nathanhollows/ace-video/config"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/oidc"
+	"github.com/nathanhollows/ace-video/sessions"
+)
+
+// pendingLoginCookies returns the cookies of a browser that started signing
+// in with the values
+func pendingLoginCookies(t *testing.T, values map[interface{}]interface{}) []*http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/login/oidc", nil)
+	w := httptest.NewRecorder()
+	session, err := sessions.Get(r, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		session.Values[key] = value
+	}
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	return w.Result().Cookies()
+}
+
+func callbackRequest(target string, cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestPendingOIDCLogin(t *testing.T) {
+	sessions.Start("0123456789abcdef0123456789abcdef")
+	started := map[interface{}]interface{}{
+		"oidc_state":    "the-state",
+		"oidc_nonce":    "the-nonce",
+		"oidc_verifier": "the-verifier",
+		"oidc_at":       time.Now().Unix(),
+	}
+	expired := map[interface{}]interface{}{
+		"oidc_state": "the-state",
+		"oidc_at":    time.Now().Add(-oidcLoginLifetime - time.Minute).Unix(),
+	}
+
+	tests := []struct {
+		name    string
+		values  map[interface{}]interface{}
+		target  string
+		wantErr bool
+	}{
+		{"matching state", started, "/login/oidc/callback?state=the-state&code=c", false},
+		{"state mismatch", started, "/login/oidc/callback?state=another-state&code=c", true},
+		{"no state", started, "/login/oidc/callback?code=c", true},
+		{"not started", nil, "/login/oidc/callback?state=&code=c", true},
+		{"expired", expired, "/login/oidc/callback?state=the-state&code=c", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := callbackRequest(tt.target, pendingLoginCookies(t, tt.values))
+			login, err := pendingOIDCLogin(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("pendingOIDCLogin() = %+v, want an error", login)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pendingOIDCLogin() error = %v", err)
+			}
+			want := oidc.Login{State: "the-state", Nonce: "the-nonce", Verifier: "the-verifier"}
+			if *login != want {
+				t.Errorf("pendingOIDCLogin() = %+v, want %+v", login, want)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	sessions.Start("0123456789abcdef0123456789abcdef")
+	helpers.SetSiteURL("https://ace.example.com")
+	defer helpers.SetSiteURL("")
+	// The provider is never contacted when the state does not match
+	oidc.Start(config.OIDC{Issuer: "http://127.0.0.1:1", ClientID: "ace-video"})
+	defer oidc.Start(config.OIDC{})
+
+	cookies := pendingLoginCookies(t, map[interface{}]interface{}{
+		"oidc_state":    "the-state",
+		"oidc_nonce":    "the-nonce",
+		"oidc_verifier": "the-verifier",
+		"oidc_at":       time.Now().Unix(),
+	})
+	w := httptest.NewRecorder()
+	adminOIDCCallbackHandler(w, callbackRequest("/login/oidc/callback?state=another-state&code=c", cookies))
+
+	res := w.Result()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "https://ace.example.com/login" {
+		t.Fatalf("callback = %d to %q, want a redirect to /login", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	// The sign in cannot be completed with the right state afterwards
+	var admin []*http.Cookie
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "admin" {
+			admin = append(admin, cookie)
+		}
+	}
+	if len(admin) == 0 {
+		t.Fatal("callback did not clear the pending sign in")
+	}
+	r := callbackRequest("/login/oidc/callback?state=the-state&code=c", admin)
+	if _, err := pendingOIDCLogin(r); err == nil {
+		t.Error("pendingOIDCLogin() worked after a failed callback")
+	}
+}
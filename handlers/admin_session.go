@@ -1,33 +1,45 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/charmbracelet/log"
+	gorillasessions "github.com/gorilla/sessions"
 	"github.com/nathanhollows/ace-video/flash"
 	"github.com/nathanhollows/ace-video/helpers"
 	"github.com/nathanhollows/ace-video/models"
+	"github.com/nathanhollows/ace-video/oidc"
+	"github.com/nathanhollows/ace-video/ratelimit"
 	"github.com/nathanhollows/ace-video/sessions"
 )
 
+var (
+	// authLimiter caps requests to the sign in and password forms from
+	// each address
+	authLimiter = ratelimit.New(30, 10)
+	// loginBackoff blocks an address for exponentially longer after
+	// repeated failed sign ins
+	loginBackoff = ratelimit.NewBackoff(5, time.Second, 15*time.Minute)
+)
+
 // adminLoginHandler is the handler for the admin login page
 func adminLoginHandler(w http.ResponseWriter, r *http.Request) {
 	data := templateData(r)
 
-	// If we're in development mode, redirect to the setup page
-	// But only if no users exist
-	if os.Getenv("DEVELOPMENT") == "true" {
-		// Check if any users exist
-		res, _ := models.CheckAnyUsers()
-		if !res {
-			http.Redirect(w, r, helpers.URL("/setup"), http.StatusSeeOther)
-			return
-		}
+	// Until the first user exists there is nobody to log in as. The setup
+	// page explains where to find the setup link.
+	if res, err := models.CheckAnyUsers(); err == nil && !res {
+		http.Redirect(w, r, helpers.URL("/setup"), http.StatusSeeOther)
+		return
 	}
 
 	data["title"] = "Login"
 	data["messages"] = flash.Get(w, r)
+	if oidc.Enabled() {
+		data["sso"] = oidc.ProviderName()
+	}
 	render(w, data, false, "login")
 }
 
@@ -37,23 +49,36 @@ func adminLoginPostHandler(w http.ResponseWriter, r *http.Request) {
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
+	// Slow down addresses with repeated failures
+	ip := ratelimit.ClientIP(r)
+	if wait := loginBackoff.Wait(ip); wait > 0 {
+		flash.Message{
+			Style:   flash.Error,
+			Title:   "Too many failed attempts",
+			Message: "Please wait " + ratelimit.Round(wait).String() + " before trying again.",
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+
 	// Try to authenticate the user
-	user, err := models.AuthenticateUser(email, password)
+	user, err := models.AuthenticateUser(r.Context(), email, password)
 	if err != nil {
-		log.Error("Error authenticating user: ", err)
+		log.FromContext(r.Context()).Warn("Failed login", "ip", ip)
+		loginBackoff.Fail(ip)
 		flash.Message{
 			Style:   flash.Error,
 			Title:   "Invalid email or password",
-			Message: "Please check your email and password and try again.",
+			Message: "Please check your email and password and try again. Accounts are locked for a while after repeated failures.",
 		}.Save(w, r)
 		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
 		return
 	}
+	loginBackoff.Reset(ip)
 
-	// Create a session
 	session, err := sessions.Get(r, "admin")
 	if err != nil {
-		log.Error("Error getting session: ", err)
+		log.FromContext(r.Context()).Error("Error getting session", "err", err)
 		flash.Message{
 			Title:   "Error",
 			Message: "An error occurred while trying to log in.",
@@ -63,14 +88,151 @@ func adminLoginPostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
 		return
 	}
-	session.Values["user_id"] = user.ID
+
+	completeLogin(w, r, session, user)
+}
+
+// completeLogin asks for the user's second factor if they have one, and
+// otherwise signs them in
+func completeLogin(w http.ResponseWriter, r *http.Request, session *gorillasessions.Session, user *models.User) {
+	if user.TOTPEnabled {
+		session.Values["pending_user_id"] = user.ID
+		session.Values["pending_at"] = time.Now().Unix()
+		session.Options.HttpOnly = true
+		session.Options.SameSite = http.SameSiteLaxMode
+		session.Save(r, w)
+		http.Redirect(w, r, helpers.URL("/login/2fa"), http.StatusSeeOther)
+		return
+	}
+
+	signIn(w, r, session, user)
+}
+
+// adminLoginTOTPHandler asks for a code from the authenticator app
+func adminLoginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := pendingUser(r); err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: err.Error(),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	data := templateData(r)
+	data["title"] = "Two factor authentication"
+	data["messages"] = flash.Get(w, r)
+	render(w, data, false, "login_2fa")
+}
+
+// adminLoginTOTPPostHandler checks the code and signs the user in
+func adminLoginTOTPPostHandler(w http.ResponseWriter, r *http.Request) {
+	user, session, err := pendingUser(r)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: err.Error(),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	ip := ratelimit.ClientIP(r)
+	if wait := loginBackoff.Wait(ip); wait > 0 {
+		flash.Message{
+			Style:   flash.Error,
+			Title:   "Too many failed attempts",
+			Message: "Please wait " + ratelimit.Round(wait).String() + " before trying again.",
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login/2fa"), http.StatusSeeOther)
+		return
+	}
+
+	err = user.VerifySecondFactor(r.Context(), r.FormValue("code"))
+	if err != nil {
+		log.FromContext(r.Context()).Warn("Failed two factor login", "ip", ip)
+		loginBackoff.Fail(ip)
+		flash.Message{
+			Title:   "Error",
+			Message: "That code is not valid. Please try again.",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login/2fa"), http.StatusSeeOther)
+		return
+	}
+	loginBackoff.Reset(ip)
+
+	signIn(w, r, session, user)
+}
+
+// pendingLoginLifetime is how long a user has to enter their second factor
+const pendingLoginLifetime = 5 * time.Minute
+
+// pendingUser finds the user who has entered their password but not yet
+// their second factor
+func pendingUser(r *http.Request) (*models.User, *gorillasessions.Session, error) {
+	expired := errors.New("Your login has expired. Please log in again.")
+	session, err := sessions.Get(r, "admin")
+	if err != nil {
+		return nil, nil, expired
+	}
+	userID, ok := session.Values["pending_user_id"].(string)
+	started, _ := session.Values["pending_at"].(int64)
+	if !ok || time.Since(time.Unix(started, 0)) > pendingLoginLifetime {
+		return nil, nil, expired
+	}
+	user, err := models.FindUserByID(userID)
+	if err != nil || user.Disabled || !user.TOTPEnabled {
+		return nil, nil, expired
+	}
+	return user, session, nil
+}
+
+// signIn creates a session for the user and sends them to the admin
+func signIn(w http.ResponseWriter, r *http.Request, session *gorillasessions.Session, user *models.User) {
+	token, err := models.NewUserSession(r.Context(), user.ID, r)
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error creating session", "err", err)
+		flash.Message{
+			Title:   "Error",
+			Message: "An error occurred while trying to log in.",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+	delete(session.Values, "pending_user_id")
+	delete(session.Values, "pending_at")
+	session.Values["session_id"] = token
+	session.Options.MaxAge = int(models.SessionLifetime().Seconds())
+	session.Options.HttpOnly = true
+	session.Options.SameSite = http.SameSiteLaxMode
 	session.Save(r, w)
 
 	http.Redirect(w, r, helpers.URL("/admin"), http.StatusSeeOther)
-
 }
 
-// Logout destroys the user session
-func Logout(w http.ResponseWriter, r *http.Request) error {
-	return nil
+// adminLogoutHandler signs the browser out and destroys the session
+func adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if current, err := models.FindSessionByRequest(r); err == nil {
+		err = current.Revoke(r.Context())
+		if err != nil {
+			log.FromContext(r.Context()).Error("Error revoking session", "err", err)
+		}
+	}
+
+	session, err := sessions.Get(r, "admin")
+	if err == nil {
+		session.Options.MaxAge = -1
+		session.Save(r, w)
+	}
+
+	flash.Message{
+		Title:   "Logged out",
+		Message: "You have been logged out.",
+		Style:   flash.Success,
+	}.Save(w, r)
+	http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
 }
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 
+	"github.com/charmbracelet/log"
 	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
 	"github.com/nathanhollows/ace-video/models"
 )
 
-// Shows the setup page
+// Shows the setup page. The form is only shown with the one time token
+// from the server log, so nobody else can claim a new server.
 func adminSetupHandler(w http.ResponseWriter, r *http.Request) {
 	setDefaultHeaders(w)
 	data := templateData(r)
@@ -17,7 +22,7 @@ func adminSetupHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		flash.Message{
 			Title:   "Error",
-			Message: err.Error(),
+			Message: errorMessage(r, "", err),
 			Style:   flash.Error,
 		}.Save(w, r)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -31,6 +36,9 @@ func adminSetupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token := r.URL.Query().Get("token")
+	data["token"] = token
+	data["valid"] = token != "" && models.CheckSetupToken(r.Context(), token)
 	data["messages"] = flash.Get(w, r)
 	render(w, data, false, "setup")
 }
@@ -38,20 +46,24 @@ func adminSetupHandler(w http.ResponseWriter, r *http.Request) {
 // Handles the setup form submission and creates the first user
 func adminSetupPostHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
+	token := r.Form.Get("token")
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
-	// Check if any users exist
-	res, err := models.CheckAnyUsers()
-	if err != nil {
+	retry := helpers.URL("/setup", "token="+url.QueryEscape(token))
+	if password != r.Form.Get("confirm_password") {
 		flash.Message{
 			Title:   "Error",
-			Message: err.Error(),
+			Message: "The passwords do not match",
 			Style:   flash.Error,
 		}.Save(w, r)
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, retry, http.StatusSeeOther)
 		return
-	} else if res {
+	}
+
+	// Create the user
+	user, err := models.CreateOwner(r.Context(), token, email, password)
+	if errors.Is(err, models.ErrAlreadySetUp) {
 		flash.Message{
 			Message: "You have already set up the system",
 			Style:   flash.Info,
@@ -59,19 +71,26 @@ func adminSetupPostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-
-	// Create the user
-	user := models.NewUser(email, password)
-	err = user.Save()
+	if errors.Is(err, models.ErrInvalidToken) {
+		log.FromContext(r.Context()).Warn("Setup attempted with an invalid token", "ip", r.RemoteAddr)
+		flash.Message{
+			Title:   "Error",
+			Message: "This setup link is invalid or has expired. Restart the server or run the setup-token command for a new one.",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/setup"), http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		flash.Message{
 			Title:   "Error",
-			Message: err.Error(),
+			Message: errorMessage(r, "", err),
 			Style:   flash.Error,
 		}.Save(w, r)
-		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		http.Redirect(w, r, retry, http.StatusSeeOther)
 		return
 	}
+	log.FromContext(r.Context()).Info("Created the first user", "user", user.ID)
 
 	flash.Message{
 		Title:   "Success",
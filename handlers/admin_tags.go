@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminTagCreateHandler adds tags to a media item. Several tags can be
+// added at once, separated by commas.
+func adminTagCreateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	for _, name := range strings.Split(r.FormValue("name"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || media.HasTag(name) {
+			continue
+		}
+		tag := &models.Tag{
+			ID:      uuid.New().String(),
+			MediaID: media.ID,
+			Name:    name,
+		}
+		err = tag.Save(r.Context())
+		if err != nil {
+			break
+		}
+		media.Tags = append(media.Tags, tag)
+	}
+
+	if isAPIRequest(r) {
+		respondMediaJSON(w, r, media, err)
+		return
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error adding tags", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Tags updated successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminTagDeleteHandler removes a tag from a media item
+func adminTagDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	// Tags can be removed by ID or by name
+	remaining := models.Tags{}
+	for _, tag := range media.Tags {
+		if tag.ID == chi.URLParam(r, "tag") || tag.Name == chi.URLParam(r, "tag") {
+			err = tag.Delete(r.Context())
+			if err != nil {
+				break
+			}
+			continue
+		}
+		remaining = append(remaining, tag)
+	}
+	media.Tags = remaining
+
+	if isAPIRequest(r) {
+		respondMediaJSON(w, r, media, err)
+		return
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error removing tag", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Tag removed successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// respondMediaJSON answers an API request with the media, or the error
+func respondMediaJSON(w http.ResponseWriter, r *http.Request, media interface{}, err error) {
+	if err != nil {
+		apiError(w, r, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(media)
+}
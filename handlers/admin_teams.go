@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminTeamsHandler lists the teams and their members
+func adminTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Teams"
+
+	data["messages"] = flash.Get(w, r)
+
+	teams, err := models.FindAllTeams(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding teams", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["teams"] = teams
+	}
+
+	users, err := models.FindAllUsers(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding users", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["users"] = users
+	}
+
+	render(w, data, true, "teams_index")
+}
+
+// adminTeamCreateHandler creates a team
+func adminTeamCreateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	team := &models.Team{Name: r.FormValue("name")}
+	err := team.Save(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error creating team", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Team created successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/teams", http.StatusSeeOther)
+}
+
+// adminTeamDeleteHandler deletes a team. Its media is shared with everyone.
+func adminTeamDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	team, err := models.FindTeamByID(r.Context(), chi.URLParam(r, "id"))
+	if err == nil {
+		err = team.Delete(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error deleting team", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Team deleted successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/teams", http.StatusSeeOther)
+}
+
+// adminTeamAddMemberHandler adds a user to a team
+func adminTeamAddMemberHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	team, err := models.FindTeamByID(r.Context(), chi.URLParam(r, "id"))
+	if err == nil {
+		var user *models.User
+		user, err = models.FindUserByID(r.FormValue("user"))
+		if err == nil {
+			err = team.AddMember(r.Context(), user.ID)
+		}
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error adding member", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Member added successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/teams", http.StatusSeeOther)
+}
+
+// adminTeamRemoveMemberHandler takes a user out of a team
+func adminTeamRemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	team, err := models.FindTeamByID(r.Context(), chi.URLParam(r, "id"))
+	if err == nil {
+		err = team.RemoveMember(r.Context(), chi.URLParam(r, "user"))
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error removing member", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Member removed successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/teams", http.StatusSeeOther)
+}
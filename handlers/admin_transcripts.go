@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminTranscriptUpdateHandler saves the transcript of a media item.
+// An uploaded WebVTT file takes precedence over the plain text field.
+func adminTranscriptUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseMultipartForm(8 << 20); err != nil && err != http.ErrNotMultipart {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error reading transcript", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	var transcript *models.Transcript
+	file, _, err := r.FormFile("file")
+	if err == nil {
+		defer file.Close()
+		content, err := io.ReadAll(file)
+		if err == nil {
+			transcript, err = models.NewTranscriptFromVTT(media.ID, string(content))
+		}
+		if err != nil {
+			flash.Message{
+				Title:   "Error",
+				Message: errorMessage(r, "Error reading WebVTT file", err),
+				Style:   flash.Error,
+			}.Save(w, r)
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+	} else {
+		transcript = models.NewTranscript(media.ID, r.FormValue("text"))
+	}
+
+	err = transcript.Save(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error saving transcript", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Transcript saved successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// adminTranscriptDeleteHandler removes the transcript of a media item
+func adminTranscriptDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	id := chi.URLParam(r, "uuid")
+	redirect := "/admin/media/" + id
+
+	media, err := models.FindMediaByID(r.Context(), id)
+	if err != nil || media.Transcript == nil {
+		flash.Message{
+			Title:   "Error",
+			Message: "This media does not have a transcript",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	err = media.Transcript.Delete(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error deleting transcript", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Transcript deleted successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminMediaDeleteHandler moves a media item to the trash
+func adminMediaDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	media, err := models.FindMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err == nil {
+		err = media.Delete(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error deleting media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Media moved to the trash",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+}
+
+// adminTrashHandler lists the media in the trash
+func adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Trash"
+
+	data["messages"] = flash.Get(w, r)
+
+	media, err := models.FindDeletedMedia(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["media"] = media
+	}
+
+	render(w, data, true, "trash")
+}
+
+// adminTrashRestoreHandler takes a media item out of the trash
+func adminTrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	media, err := models.FindDeletedMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err == nil {
+		err = media.Restore(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error restoring media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Media restored successfully",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// adminTrashPurgeHandler permanently deletes a media item and its files
+func adminTrashPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	media, err := models.FindDeletedMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err == nil {
+		err = media.Purge(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error purging media", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Media permanently deleted",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// adminTrashEmptyHandler permanently deletes everything in the trash
+func adminTrashEmptyHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	n, err := models.EmptyTrash(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error emptying the trash", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: fmt.Sprintf("%d items permanently deleted", n),
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// adminUsersHandler lists the users
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Users"
+
+	data["messages"] = flash.Get(w, r)
+
+	users, err := models.FindAllUsers(r.Context())
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error finding users", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		data["users"] = users
+	}
+	data["roles"] = models.Roles
+
+	render(w, data, true, "users_index")
+}
+
+// adminUserInviteHandler creates a user and shows the link they use to set
+// their password
+func adminUserInviteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, token, err := models.InviteUser(r.Context(),
+		r.FormValue("email"),
+		models.Role(r.FormValue("role")),
+	)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error inviting user", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	link := helpers.URL("/invite/" + token)
+	err = sendEmail(r.Context(), user.Email, "You have been invited to "+settings.AppName, "invite", map[string]interface{}{
+		"role": user.Role,
+		"link": link,
+	})
+	if err != nil {
+		// Let the owner pass the link on themselves
+		flash.Message{
+			Title:   "User invited",
+			Message: errorMessage(r, "The invitation email could not be sent", err) + " Send them this link to set a password. It expires in 7 days: " + link,
+			Style:   flash.Warning,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "User invited",
+			Message: "An invitation has been emailed to " + user.Email,
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserRoleHandler changes a user's role
+func adminUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, err := findOtherUser(r)
+	if err == nil {
+		role := models.Role(r.FormValue("role"))
+		if !role.Valid() {
+			err = models.NewUserError("unknown role")
+		} else {
+			user.Role = role
+			err = user.Update(r.Context())
+		}
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating user", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " is now " + string(user.Role),
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserDisableHandler stops a user from signing in
+func adminUserDisableHandler(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, true)
+}
+
+// adminUserEnableHandler lets a disabled user sign in again
+func adminUserEnableHandler(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, false)
+}
+
+func setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	setDefaultHeaders(w)
+
+	user, err := findOtherUser(r)
+	if err == nil {
+		user.Disabled = disabled
+		err = user.Update(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating user", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else if disabled {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " has been disabled",
+			Style:   flash.Success,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " has been enabled",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserUnlockHandler lets a user locked out by failed sign ins try again
+func adminUserUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, err := findOtherUser(r)
+	if err == nil {
+		err = user.Unlock(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error unlocking user", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " has been unlocked",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserRequireTOTPHandler sets whether the user must use two factor
+// authentication
+func adminUserRequireTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, err := findOtherUser(r)
+	if err == nil {
+		user.TOTPRequired = r.FormValue("required") == "true"
+		err = user.Update(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error updating user", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else if user.TOTPRequired {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " must now use two factor authentication",
+			Style:   flash.Success,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " no longer has to use two factor authentication",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserResetTOTPHandler removes a user's two factor authentication,
+// such as when they have lost their phone and recovery codes
+func adminUserResetTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, err := findOtherUser(r)
+	if err == nil {
+		err = user.DisableTOTP(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error resetting two factor authentication", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: "Two factor authentication has been reset for " + user.Email,
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserDeleteHandler deletes a user
+func adminUserDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+
+	user, err := findOtherUser(r)
+	if err == nil {
+		err = user.Delete(r.Context())
+	}
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error deleting user", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+	} else {
+		flash.Message{
+			Title:   "Success",
+			Message: user.Email + " has been deleted",
+			Style:   flash.Success,
+		}.Save(w, r)
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// findOtherUser finds the user being managed. Owners cannot change their
+// own account here, which also means there is always an owner left.
+func findOtherUser(r *http.Request) (*models.User, error) {
+	id := chi.URLParam(r, "id")
+	if current, ok := models.UserFromContext(r.Context()); ok && current.ID == id {
+		return nil, models.NewForbiddenError("you cannot change your own account")
+	}
+	return models.FindUserByID(id)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/sessions"
+)
+
+const (
+	// csrfField is the form field that carries the token
+	csrfField = "csrf_token"
+	// csrfHeader carries the token for htmx and fetch requests
+	csrfHeader = "X-CSRF-Token"
+	// csrfTokenLength is the length of the secret in bytes
+	csrfTokenLength = 32
+)
+
+// maxFormSize limits how much of a form body is read to find the token.
+// Multipart forms are only read up to maxTokenPeek, as the token is their
+// first field, so uploads are not read before the handler checks who sent
+// them.
+const (
+	maxFormSize  = 1 << 20
+	maxTokenPeek = 16 << 10
+)
+
+type csrfContextKey struct{}
+
+// csrfSecret is the secret for the browser's session. It is only created,
+// along with the session cookie, when a page asks for a token, so requests
+// for media, assets and health checks do not set a cookie.
+type csrfSecret struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	value []byte
+}
+
+// get returns the secret, creating it if the session does not have one
+func (s *csrfSecret) get() ([]byte, error) {
+	if s.value != nil {
+		return s.value, nil
+	}
+	session, err := sessions.Get(s.r, "admin")
+	if err != nil {
+		log.FromContext(s.r.Context()).Warn("Replacing unreadable session cookie", "err", err)
+	}
+	secret, _ := session.Values["csrf_token"].([]byte)
+	if len(secret) != csrfTokenLength {
+		secret = make([]byte, csrfTokenLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		session.Values["csrf_token"] = secret
+		session.Options.HttpOnly = true
+		session.Options.SameSite = http.SameSiteLaxMode
+		if err := session.Save(s.r, s.w); err != nil {
+			return nil, err
+		}
+	}
+	s.value = secret
+	return secret, nil
+}
+
+// csrfMiddleware rejects unsafe requests that do not carry the token for
+// the browser's session. The secret is kept in the admin session cookie,
+// so it lasts until the browser logs out.
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// API tokens are sent explicitly rather than by the browser, so
+		// those requests cannot be forged
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			// A browser without a secret cannot have a valid token
+			session, _ := sessions.Get(r, "admin")
+			secret, _ := session.Values["csrf_token"].([]byte)
+			if !validCSRFToken(requestCSRFToken(w, r), secret) {
+				log.FromContext(r.Context()).Warn("Rejected request without a valid CSRF token", "path", r.URL.Path, "ip", r.RemoteAddr)
+				csrfFailureHandler(w, r)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), csrfContextKey{}, &csrfSecret{w: w, r: r})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestCSRFToken finds the token in the header or the form
+func requestCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return multipartCSRFToken(r, params["boundary"])
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	return r.PostFormValue(csrfField)
+}
+
+// multipartCSRFToken reads the token from the first field of a multipart
+// form. What was read is put back, so the handler still sees the whole body.
+func multipartCSRFToken(r *http.Request, boundary string) string {
+	var read bytes.Buffer
+	form := multipart.NewReader(io.TeeReader(io.LimitReader(r.Body, maxTokenPeek), &read), boundary)
+	defer func() {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&read, r.Body), r.Body}
+	}()
+
+	part, err := form.NextPart()
+	if err != nil || part.FormName() != csrfField || part.FileName() != "" {
+		return ""
+	}
+	token, err := io.ReadAll(io.LimitReader(part, 4*csrfTokenLength))
+	if err != nil {
+		return ""
+	}
+	return string(token)
+}
+
+// csrfToken returns a token for the request's session. Each token is masked
+// with a fresh one time pad so that it differs on every page, which stops
+// the secret from leaking through compressed responses.
+func csrfToken(r *http.Request) string {
+	lazy, ok := r.Context().Value(csrfContextKey{}).(*csrfSecret)
+	if !ok {
+		return ""
+	}
+	secret, err := lazy.get()
+	if err != nil {
+		log.FromContext(r.Context()).Error("Error creating CSRF token", "err", err)
+		return ""
+	}
+	pad := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(pad); err != nil {
+		log.FromContext(r.Context()).Error("Error generating CSRF token", "err", err)
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(append(pad, xorBytes(pad, secret)...))
+}
+
+// validCSRFToken unmasks the token and compares it with the secret
+func validCSRFToken(token string, secret []byte) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*csrfTokenLength {
+		return false
+	}
+	unmasked := xorBytes(b[:csrfTokenLength], b[csrfTokenLength:])
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// csrfFuncs are the template functions for embedding the request's token.
+// The token is only made when a template uses one of them.
+func csrfFuncs(newToken func() string) template.FuncMap {
+	token := ""
+	get := func() string {
+		if token == "" && newToken != nil {
+			token = newToken()
+		}
+		return token
+	}
+	return template.FuncMap{
+		// csrfField is a hidden input to place inside each form
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + template.HTMLEscapeString(get()) + `" />`)
+		},
+		// csrfToken is the raw token for scripts
+		"csrfToken": func() string {
+			return get()
+		},
+		// csrfHeaders is an hx-headers value so htmx sends the token
+		"csrfHeaders": func() string {
+			return `{"` + csrfHeader + `": "` + get() + `"}`
+		},
+	}
+}
+
+// csrfFailureHandler explains why the form was rejected
+func csrfFailureHandler(w http.ResponseWriter, r *http.Request) {
+	message := "Invalid or missing CSRF token. Reload the page and try again."
+	if wantsProblem(r) {
+		writeProblem(w, r, http.StatusForbidden, message)
+		return
+	} else if r.Header.Get("HX-Request") == "true" {
+		http.Error(w, message, http.StatusForbidden)
+		return
+	}
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Forbidden"
+	renderStatus(w, http.StatusForbidden, data, false, "csrf")
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nathanhollows/ace-video/mailer"
+)
+
+// sendEmail renders a plain text template from templates/email and sends it
+func sendEmail(ctx context.Context, to, subject, name string, data map[string]interface{}) error {
+	tmpl, err := email(name)
+	if err != nil {
+		return err
+	}
+	data["app"] = settings.AppName
+
+	var body strings.Builder
+	err = tmpl.Execute(&body, data)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mailer.Message{
+		To:      to,
+		Subject: subject,
+		Body:    body.String(),
+	})
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// problem describes an error in an API response, following RFC 7807.
+// RequestID is an extension member so the log entry can be found.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorStatus is the HTTP status for the kind of error
+func errorStatus(err error) int {
+	switch models.ErrorKind(err) {
+	case models.KindInvalid:
+		return http.StatusBadRequest
+	case models.KindNotFound:
+		return http.StatusNotFound
+	case models.KindConflict:
+		return http.StatusConflict
+	case models.KindUnauthorized:
+		return http.StatusUnauthorized
+	case models.KindForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorMessage describes err for a flash message or error response. Errors
+// meant for users are shown after the prefix. Anything else is logged, and
+// the message gives the request ID so the log entry can be found.
+func errorMessage(r *http.Request, prefix string, err error) string {
+	var userErr *models.UserError
+	detail := ""
+	if errors.As(err, &userErr) {
+		detail = userErr.Error()
+	} else if models.ErrorKind(err) == models.KindNotFound {
+		detail = "it could not be found"
+	}
+	if detail != "" && prefix == "" {
+		return detail
+	} else if detail != "" {
+		return prefix + ": " + detail
+	}
+
+	if prefix == "" {
+		prefix = "Something went wrong"
+	}
+	log.FromContext(r.Context()).Error(prefix, "err", err)
+	return withReference(prefix, requestID(r))
+}
+
+// withReference adds the request ID to a message about an error
+func withReference(message, id string) string {
+	return message + ". Please quote reference " + id + " if you report this problem."
+}
+
+// wantsProblem reports whether the client expects errors as problem
+// details rather than a page, such as scripts using an API token
+func wantsProblem(r *http.Request) bool {
+	if isAPIRequest(r) || r.Header.Get("Authorization") != "" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, "application/problem+json")
+}
+
+// writeProblem answers with problem details. The detail is shown to the
+// client, so it must not reveal internals.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestID(r),
+	})
+}
+
+// apiError answers an API request with err as problem details, using the
+// status for the kind of error
+func apiError(w http.ResponseWriter, r *http.Request, prefix string, err error) {
+	writeProblem(w, r, errorStatus(err), errorMessage(r, prefix, err))
+}
+
+// errorPage explains an error to a browser that cannot be sent back to a
+// form with a flash message, such as a missing page
+func errorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = http.StatusText(status)
+	data["heading"] = http.StatusText(status)
+	data["message"] = message
+	renderStatus(w, status, data, false, "error")
+}
+
+// respondError answers with err as problem details or an error page,
+// depending on what the client expects
+func respondError(w http.ResponseWriter, r *http.Request, prefix string, err error) {
+	if wantsProblem(r) {
+		apiError(w, r, prefix, err)
+		return
+	}
+	errorPage(w, r, errorStatus(err), errorMessage(r, prefix, err))
+}
+
+// notFoundHandler answers requests for routes that do not exist
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, "", models.NewNotFoundError("This page could not be found"))
+}
+
+// methodNotAllowedHandler answers requests that use the wrong method for
+// a route
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusMethodNotAllowed
+	if wantsProblem(r) {
+		writeProblem(w, r, status, "This route does not accept "+r.Method+" requests")
+		return
+	}
+	errorPage(w, r, status, "This page does not accept "+r.Method+" requests")
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// mediaDir is where uploads are stored
+const mediaDir = "assets/media"
+
+// draining is set once the server starts shutting down, so the
+// orchestrator stops sending it new requests
+var draining atomic.Bool
+
+// healthCheck is a dependency the server needs to handle requests
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+var healthChecks = []healthCheck{
+	{"database", models.Ping},
+	{"storage", checkStorage},
+}
+
+// healthzHandler reports whether the server is working, for liveness probes
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	respondHealth(w, r, false)
+}
+
+// readyzHandler reports whether the server should receive traffic, for
+// readiness probes. It fails while the server is shutting down.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	respondHealth(w, r, draining.Load())
+}
+
+// respondHealth runs the checks and responds 200 when all pass, or 503
+func respondHealth(w http.ResponseWriter, r *http.Request, draining bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := map[string]string{}
+	for _, c := range healthChecks {
+		if err := c.check(ctx); err != nil {
+			// The endpoint is public, so the error is only logged
+			log.FromContext(r.Context()).Error("Health check failed", "check", c.name, "err", err)
+			checks[c.name] = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[c.name] = "ok"
+	}
+	if draining {
+		checks["server"] = "shutting down"
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": http.StatusText(status),
+		"checks": checks,
+	})
+}
+
+// checkStorage writes and removes a file in the media directory
+func checkStorage(ctx context.Context) error {
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(mediaDir, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("ok"))
+	return errors.Join(err, f.Close(), os.Remove(f.Name()))
+}
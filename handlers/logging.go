@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/tracing"
+)
+
+type requestIDContextKey struct{}
+
+// validRequestID limits the request IDs accepted from a proxy, since they
+// are written to the log and shown to users
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestLogger gives each request an ID and a logger that includes it,
+// which models and handlers find with log.FromContext, then logs the
+// request once it has been handled. An X-Request-ID from a proxy is kept so
+// its logs can be matched with ours. The trace ID is logged too when the
+// request is traced.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		logger := log.With("request_id", id)
+		if trace := tracing.TraceID(r.Context()); trace != "" {
+			logger = logger.With("trace_id", trace)
+		}
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, id)
+		ctx = log.WithContext(ctx, logger)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		logger.Info("Request",
+			"method", r.Method,
+			"path", helpers.RedactedPath(r),
+			"route", route,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start).Round(time.Microsecond),
+			"ip", r.RemoteAddr,
+		)
+	})
+}
+
+// requestID returns the ID that the request is logged with
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey{}).(string)
+	return id
+}
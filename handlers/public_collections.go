@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// publicCollectionHandler shows a collection that plays its media in order
+func publicCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	collection, err := models.FindCollectionByID(r.Context(), chi.URLParam(r, "uuid"))
+	if models.ErrorKind(err) == models.KindNotFound {
+		publicRemovedHandler(w, r, false)
+		return
+	} else if err != nil {
+		respondError(w, r, "Error finding collection", err)
+		return
+	}
+
+	data["title"] = collection.Title
+	data["collection"] = collection
+	data["media"] = collection.Library()
+	render(w, data, false, "collection")
+}
@@ -4,10 +4,22 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/nathanhollows/ace-video/models"
+	"github.com/nathanhollows/ace-video/ratelimit"
 )
 
+// dataLimiter caps requests to the public JSON from each address
+var dataLimiter = ratelimit.New(120, 60)
+
+// sortColumns are the columns media can be sorted by
+var sortColumns = map[string]string{
+	"created_at": "media.created_at",
+	"updated_at": "media.updated_at",
+	"title":      "media.title",
+}
+
 func publicDataJSONHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -16,10 +28,14 @@ func publicDataJSONHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Pragma", "public")
 
 	options, err := parseJSONOptions(r)
+	if err != nil {
+		apiError(w, r, "Invalid options", err)
+		return
+	}
 
 	media, err := models.FindMatchingMedia(r.Context(), options)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiError(w, r, "Error finding media", err)
 		return
 	}
 	json.NewEncoder(w).Encode(media)
@@ -30,27 +46,39 @@ func parseJSONOptions(r *http.Request) (models.JSONOptions, error) {
 	options := models.JSONOptions{
 		Limit:  10,
 		Offset: 0,
-		Sort:   "created_at",
+		Sort:   sortColumns["created_at"],
 		Order:  "desc",
 	}
 	if limit := r.URL.Query().Get("limit"); limit != "" {
 		limitInt, err := strconv.Atoi(limit)
 		if err != nil {
-			return options, err
+			return options, models.NewUserError("limit must be a whole number")
+		} else if limitInt < 0 {
+			return options, models.NewUserError("limit must not be negative")
 		}
 		options.Limit = limitInt
 	}
 	if offset := r.URL.Query().Get("offset"); offset != "" {
 		offsetInt, err := strconv.Atoi(offset)
 		if err != nil {
-			return options, err
+			return options, models.NewUserError("offset must be a whole number")
+		} else if offsetInt < 0 {
+			return options, models.NewUserError("offset must not be negative")
 		}
 		options.Offset = offsetInt
 	}
+	// Both are written into the query, so only known values are allowed
 	if sort := r.URL.Query().Get("sort"); sort != "" {
-		options.Sort = sort
+		column, ok := sortColumns[sort]
+		if !ok {
+			return options, models.NewUserError("sort must be created_at, updated_at or title")
+		}
+		options.Sort = column
 	}
-	if order := r.URL.Query().Get("order"); order != "" {
+	if order := strings.ToLower(r.URL.Query().Get("order")); order != "" {
+		if order != "asc" && order != "desc" {
+			return options, models.NewUserError("order must be asc or desc")
+		}
 		options.Order = order
 	}
 	if search := r.URL.Query().Get("search"); search != "" {
@@ -65,5 +93,13 @@ func parseJSONOptions(r *http.Request) (models.JSONOptions, error) {
 	if id := r.URL.Query().Get("id"); id != "" {
 		options.ID = id
 	}
+	if collection := r.URL.Query().Get("collection"); collection != "" {
+		options.Collection = collection
+		// Collections are listed in their own order unless asked otherwise
+		if r.URL.Query().Get("sort") == "" {
+			options.Sort = "collection_item.position"
+			options.Order = "asc"
+		}
+	}
 	return options, nil
 }
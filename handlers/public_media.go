@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// publicMediaHandler shows the landing page for a media item.
+// A ?t= query starts playback at the given position, e.g. ?t=4:30
+func publicMediaHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+
+	media, err := models.FindMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if models.ErrorKind(err) == models.KindNotFound {
+		publicRemovedHandler(w, r, models.WasMediaDeleted(r.Context(), chi.URLParam(r, "uuid")))
+		return
+	} else if err != nil {
+		respondError(w, r, "Error finding media", err)
+		return
+	}
+
+	var start float64
+	if t := r.URL.Query().Get("t"); t != "" {
+		start, _ = helpers.ParseTimestamp(t)
+	} else if q := r.URL.Query().Get("q"); q != "" && media.Transcript != nil {
+		// Start where the search term was said
+		if cue, ok := media.Transcript.Cues().Find(q); ok {
+			start = cue.Start
+		}
+	}
+
+	data["title"] = media.Title
+	data["media"] = media
+	data["start"] = start
+	data["search"] = r.URL.Query().Get("q")
+	render(w, data, false, "media")
+}
+
+// publicRemovedHandler tells visitors following an old QR code that the
+// item they are looking for has been removed
+func publicRemovedHandler(w http.ResponseWriter, r *http.Request, deleted bool) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Removed"
+
+	status := http.StatusNotFound
+	if deleted {
+		status = http.StatusGone
+	}
+	renderStatus(w, status, data, false, "removed")
+}
+
+// publicChaptersVTTHandler serves the chapters of a media item as WebVTT
+func publicChaptersVTTHandler(w http.ResponseWriter, r *http.Request) {
+	media, err := models.FindMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err != nil {
+		apiError(w, r, "Error finding media", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte(media.Chapters.WebVTT()))
+}
+
+// publicTranscriptVTTHandler serves a WebVTT transcript as a captions track
+func publicTranscriptVTTHandler(w http.ResponseWriter, r *http.Request) {
+	media, err := models.FindMediaByID(r.Context(), chi.URLParam(r, "uuid"))
+	if err == nil && (media.Transcript == nil || media.Transcript.Format != models.TranscriptVTT) {
+		err = models.NewNotFoundError("it does not have a WebVTT transcript")
+	}
+	if err != nil {
+		apiError(w, r, "Error finding media", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write([]byte(media.Transcript.Content))
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi"
+	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/models"
+)
+
+// resetTokenLifetime is how long a password reset link works
+const resetTokenLifetime = time.Hour
+
+// publicInviteHandler shows the form for an invited user to set a password
+func publicInviteHandler(w http.ResponseWriter, r *http.Request) {
+	renderPasswordForm(w, r, models.TokenInvite, "invite", "You have been invited as ")
+}
+
+// publicInvitePostHandler sets the invited user's password
+func publicInvitePostHandler(w http.ResponseWriter, r *http.Request) {
+	setPasswordWithToken(w, r, models.TokenInvite, "invite", "Your password has been set. You can now log in.")
+}
+
+// publicForgotHandler shows the form to request a password reset
+func publicForgotHandler(w http.ResponseWriter, r *http.Request) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Forgot password"
+	data["messages"] = flash.Get(w, r)
+	render(w, data, false, "forgot")
+}
+
+// publicForgotPostHandler emails a password reset link. The response is the
+// same, and as quick, whether or not the account exists.
+func publicForgotPostHandler(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+
+	// The link is sent after responding, so the time taken to send it does
+	// not show which addresses have accounts
+	ctx := log.WithContext(context.Background(), log.FromContext(r.Context()))
+	go sendPasswordReset(ctx, email)
+
+	flash.Message{
+		Title:   "Check your email",
+		Message: "If an account exists for that address, we have sent it a link to reset the password.",
+		Style:   flash.Info,
+	}.Save(w, r)
+	http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+}
+
+// sendPasswordReset emails a password reset link if the address belongs to
+// an active account
+func sendPasswordReset(ctx context.Context, email string) {
+	user, err := models.FindUserByEmail(email)
+	if err != nil || user.Disabled {
+		return
+	}
+	token, err := models.NewUserToken(ctx, user.ID, models.TokenReset, resetTokenLifetime)
+	if err == nil {
+		err = sendEmail(ctx, user.Email, "Reset your "+settings.AppName+" password", "reset", map[string]interface{}{
+			"link": helpers.URL("/reset/" + token),
+		})
+	}
+	if err != nil {
+		log.FromContext(ctx).Error("Error sending password reset", "err", err)
+	}
+}
+
+// publicResetHandler shows the form to choose a new password
+func publicResetHandler(w http.ResponseWriter, r *http.Request) {
+	renderPasswordForm(w, r, models.TokenReset, "reset", "Choose a new password for ")
+}
+
+// publicResetPostHandler sets the new password and signs the user out of
+// every session
+func publicResetPostHandler(w http.ResponseWriter, r *http.Request) {
+	setPasswordWithToken(w, r, models.TokenReset, "reset", "Your password has been reset. You can now log in.")
+}
+
+// renderPasswordForm shows the set password form for a valid token
+func renderPasswordForm(w http.ResponseWriter, r *http.Request, purpose models.TokenPurpose, path, intro string) {
+	setDefaultHeaders(w)
+	data := templateData(r)
+	data["title"] = "Set your password"
+
+	token := chi.URLParam(r, "token")
+	userToken, err := models.FindUserToken(r.Context(), token, purpose)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	data["intro"] = intro + userToken.User.Email
+	data["action"] = helpers.URL("/" + path + "/" + token)
+	data["messages"] = flash.Get(w, r)
+	render(w, data, false, "password")
+}
+
+// setPasswordWithToken uses the token to set the user's password
+func setPasswordWithToken(w http.ResponseWriter, r *http.Request, purpose models.TokenPurpose, path, success string) {
+	token := chi.URLParam(r, "token")
+	password := r.FormValue("password")
+
+	userToken, err := models.FindUserToken(r.Context(), token, purpose)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	if password == "" {
+		flash.Message{
+			Title:   "Error",
+			Message: "Please choose a password",
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/"+path+"/"+token), http.StatusSeeOther)
+		return
+	}
+	if err := models.ValidatePassword(password, userToken.User.Email); err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: err.Error(),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/"+path+"/"+token), http.StatusSeeOther)
+		return
+	}
+
+	err = userToken.SetPassword(r.Context(), password)
+	if err != nil {
+		flash.Message{
+			Title:   "Error",
+			Message: errorMessage(r, "Error setting your password", err),
+			Style:   flash.Error,
+		}.Save(w, r)
+		http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	flash.Message{
+		Title:   "Success",
+		Message: success,
+		Style:   flash.Success,
+	}.Save(w, r)
+	http.Redirect(w, r, helpers.URL("/login"), http.StatusSeeOther)
+}
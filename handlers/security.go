@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/config"
+)
+
+// securityPolicy sets the Content-Security-Policy and other security
+// headers
+type securityPolicy struct {
+	siteOrigin   string
+	embedOrigins []string
+	hstsMaxAge   int
+	reportURI    string
+}
+
+type nonceContextKey struct{}
+
+// newSecurityPolicy builds the policy from the settings
+func newSecurityPolicy(c *config.Config) *securityPolicy {
+	p := &securityPolicy{
+		embedOrigins: c.Security.EmbedOrigins,
+		reportURI:    c.Security.CSPReportURI,
+	}
+	// HSTS would stop browsers reaching a site that is only served over http
+	if strings.HasPrefix(c.SiteURL, "https://") {
+		p.hstsMaxAge = c.Security.HSTSMaxAge
+	}
+	if site, err := url.Parse(c.SiteURL); err == nil {
+		p.siteOrigin = site.Scheme + "://" + site.Host
+	}
+	return p
+}
+
+// Middleware sets the headers on every response. Pages can only be framed
+// by this site unless the route allows embedding.
+func (p *securityPolicy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			log.FromContext(r.Context()).Error("Error generating CSP nonce", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(b)
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", p.csp(nonce, nil))
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Permissions-Policy", p.permissions(nil))
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if p.hstsMaxAge > 0 {
+			h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(p.hstsMaxAge)+"; includeSubDomains")
+		}
+
+		ctx := context.WithValue(r.Context(), nonceContextKey{}, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AllowEmbedding lets the configured origins frame the routes
+func (p *securityPolicy) AllowEmbedding(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(p.embedOrigins) > 0 {
+			h := w.Header()
+			h.Set("Content-Security-Policy", p.csp(cspNonce(r), p.embedOrigins))
+			h.Set("Permissions-Policy", p.permissions(p.embedOrigins))
+			// X-Frame-Options cannot list origins, and browsers that
+			// support frame-ancestors ignore it
+			h.Del("X-Frame-Options")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csp builds the Content-Security-Policy. Scripts must carry the nonce,
+// so inline event handlers are not allowed.
+func (p *securityPolicy) csp(nonce string, frameAncestors []string) string {
+	media := strings.TrimSpace("'self' data: blob: " + p.siteOrigin)
+	directives := []string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src " + media,
+		"media-src " + media,
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + strings.Join(append([]string{"'self'"}, frameAncestors...), " "),
+	}
+	if p.reportURI != "" {
+		directives = append(directives, "report-uri "+p.reportURI)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// permissions builds the Permissions-Policy. Frames on the embedding
+// origins may still play video full screen.
+func (p *securityPolicy) permissions(embedOrigins []string) string {
+	allowed := "self"
+	for _, origin := range embedOrigins {
+		allowed += ` "` + origin + `"`
+	}
+	return strings.Join([]string{
+		"accelerometer=()",
+		"camera=()",
+		"geolocation=()",
+		"gyroscope=()",
+		"magnetometer=()",
+		"microphone=()",
+		"payment=()",
+		"usb=()",
+		"autoplay=(" + allowed + ")",
+		"fullscreen=(" + allowed + ")",
+		"picture-in-picture=(" + allowed + ")",
+	}, ", ")
+}
+
+// cspNonce returns the nonce that inline scripts need for this request
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceContextKey{}).(string)
+	return nonce
+}
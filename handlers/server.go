@@ -1,59 +1,189 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"html/template"
-	"log"
+	"io/fs"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/gomarkdown/markdown"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/nathanhollows/ace-video/config"
 	"github.com/nathanhollows/ace-video/filesystem"
 	"github.com/nathanhollows/ace-video/flash"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/metrics"
 	"github.com/nathanhollows/ace-video/models"
 	"github.com/nathanhollows/ace-video/sessions"
+	"github.com/nathanhollows/ace-video/tracing"
 )
 
 var router *chi.Mux
 var server *http.Server
 
-type userContextKey string
+// settings is the configuration the server was started with
+var settings *config.Config
 
-func Start() {
+// Start serves requests until the process receives SIGINT or SIGTERM. It
+// then reports that it is not ready for the drain delay, stops accepting
+// connections and waits for in-flight requests, such as uploads, to finish.
+func Start(c *config.Config) error {
+	settings = c
 
 	createRoutes()
+	registerGauges()
 
 	server = &http.Server{
-		Addr:    os.Getenv("SERVER_ADDR"),
-		Handler: router,
+		Addr:              c.ServerAddr,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       c.Timeouts.Read,
+		WriteTimeout:      c.Timeouts.Write,
+		IdleTimeout:       c.Timeouts.Idle,
+		ErrorLog:          log.StandardLog(log.StandardLogOptions{ForceLevel: log.ErrorLevel}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal stops the server immediately
+	stop()
+
+	// Readiness probes need time to notice before the listener closes
+	draining.Store(true)
+	if c.Timeouts.Drain > 0 {
+		log.Info("Shutting down, draining traffic", "delay", c.Timeouts.Drain)
+		time.Sleep(c.Timeouts.Drain)
+	}
+
+	log.Info("Shutting down, waiting for requests to finish", "timeout", c.Timeouts.Shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeouts.Shutdown)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	return nil
+}
+
+// registerGauges reports state that is read when metrics are scraped
+func registerGauges() {
+	metrics.Gauge("active_sessions", "Signed in browser sessions that have not expired.", func() float64 {
+		n, err := models.CountActiveSessions(context.Background())
+		if err != nil {
+			log.Error("Error counting sessions", "err", err)
+		}
+		return float64(n)
+	})
+}
+
+type responseControllerKey struct{}
+
+// responseControl keeps a controller for the server's ResponseWriter in the
+// context, so handlers can extend deadlines through middleware whose
+// writers cannot be unwrapped. It must run before any middleware that wraps
+// the ResponseWriter.
+func responseControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// extendUploadDeadlines gives an upload longer to read the request and
+// write the response than other requests. Handlers call it once the user
+// is signed in, so anonymous clients cannot hold connections open.
+func extendUploadDeadlines(r *http.Request) {
+	rc, ok := r.Context().Value(responseControllerKey{}).(*http.ResponseController)
+	if !ok {
+		return
+	}
+	deadline := time.Now().Add(settings.Timeouts.Upload)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.FromContext(r.Context()).Error("Error extending upload read deadline", "err", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.FromContext(r.Context()).Error("Error extending upload write deadline", "err", err)
 	}
-	fmt.Println(server.ListenAndServe())
 }
 
 func createRoutes() {
+	security := newSecurityPolicy(settings)
+
 	router = chi.NewRouter()
+	// These are set before the middleware, which chi would otherwise run
+	// a second time for them
+	router.NotFound(notFoundHandler)
+	router.MethodNotAllowed(methodNotAllowedHandler)
+	router.Use(responseControl)
+	router.Use(tracing.Middleware)
+	router.Use(requestLogger)
+	router.Use(metrics.Middleware)
 	router.Use(middleware.Compress(5))
 	router.Use(middleware.CleanPath)
 	router.Use(middleware.StripSlashes)
 	router.Use(middleware.RedirectSlashes)
+	router.Use(security.Middleware)
+	router.Use(csrfMiddleware)
+
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, helpers.URL("/admin"), http.StatusSeeOther)
+	})
 
-	router.Get("/", adminMediaHandler)
+	// Health checks for the container orchestrator
+	router.Get("/healthz", healthzHandler)
+	router.Get("/readyz", readyzHandler)
+	router.Method(http.MethodGet, "/metrics", metrics.Handler(settings.Metrics.Token))
 
-	router.Get("/data.json", publicDataJSONHandler)
+	router.With(dataLimiter.Middleware).Get("/data.json", publicDataJSONHandler)
+
+	// Public media landing pages, which an LMS may embed
+	router.With(security.AllowEmbedding).Get("/media/{uuid}", publicMediaHandler)
+	router.Get("/media/{uuid}/chapters.vtt", publicChaptersVTTHandler)
+	router.Get("/media/{uuid}/transcript.vtt", publicTranscriptVTTHandler)
+	router.With(security.AllowEmbedding).Get("/collections/{uuid}", publicCollectionHandler)
 
 	// Session routes
 	router.Get("/login", adminLoginHandler)
-	router.Post("/login", adminLoginPostHandler)
+	router.With(authLimiter.Middleware).Post("/login", adminLoginPostHandler)
+	router.Get("/login/2fa", adminLoginTOTPHandler)
+	router.With(authLimiter.Middleware).Post("/login/2fa", adminLoginTOTPPostHandler)
+	router.Get("/login/oidc", adminOIDCLoginHandler)
+	router.With(authLimiter.Middleware).Get("/login/oidc/callback", adminOIDCCallbackHandler)
+	router.Post("/logout", adminLogoutHandler)
 
 	// Setup
 	router.Get("/setup", adminSetupHandler)
-	router.Post("/setup", adminSetupPostHandler)
+	router.With(authLimiter.Middleware).Post("/setup", adminSetupPostHandler)
+
+	// Invitations and password resets
+	router.Get("/invite/{token}", publicInviteHandler)
+	router.With(authLimiter.Middleware).Post("/invite/{token}", publicInvitePostHandler)
+	router.Get("/forgot", publicForgotHandler)
+	router.With(authLimiter.Middleware).Post("/forgot", publicForgotPostHandler)
+	router.Get("/reset/{token}", publicResetHandler)
+	router.With(authLimiter.Middleware).Post("/reset/{token}", publicResetPostHandler)
 
 	router.Route("/admin", func(r chi.Router) {
 		r.Use(adminAuthMiddleware)
@@ -62,21 +192,102 @@ func createRoutes() {
 		r.Get("/", adminMediaHandler)
 		r.Route("/media", func(r chi.Router) {
 			r.Get("/", adminMediaHandler)
-			r.Post("/", adminMediaUploadHandler)
-			r.Post("/{uuid}", adminMediaUpdateHandler)
+			r.Get("/{uuid}", adminMediaEditHandler)
+			r.Get("/{uuid}/history", adminMediaHistoryHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(requireRole(models.RoleEditor))
+				r.Post("/", adminMediaUploadHandler)
+				r.Post("/{uuid}", adminMediaUpdateHandler)
+				r.Post("/{uuid}/metadata", adminMediaMetadataHandler)
+				r.Post("/{uuid}/tags", adminTagCreateHandler)
+				r.Post("/{uuid}/tags/{tag}/delete", adminTagDeleteHandler)
+				r.Post("/{uuid}/team", adminMediaTeamHandler)
+				r.Post("/{uuid}/delete", adminMediaDeleteHandler)
+				r.Post("/{uuid}/replace", adminMediaReplaceHandler)
+				r.Post("/{uuid}/versions/{version}/restore", adminMediaRollbackHandler)
+				r.Post("/{uuid}/history/{entry}/revert", adminMediaRevertHandler)
+				r.Post("/{uuid}/chapters", adminChapterCreateHandler)
+				r.Post("/{uuid}/chapters/{chapter}", adminChapterUpdateHandler)
+				r.Post("/{uuid}/chapters/{chapter}/delete", adminChapterDeleteHandler)
+				r.Post("/{uuid}/transcript", adminTranscriptUpdateHandler)
+				r.Post("/{uuid}/transcript/delete", adminTranscriptDeleteHandler)
+			})
+		})
+		r.Route("/trash", func(r chi.Router) {
+			r.Use(requireRole(models.RoleEditor))
+			r.Get("/", adminTrashHandler)
+			r.Post("/purge", adminTrashEmptyHandler)
+			r.Post("/{uuid}/restore", adminTrashRestoreHandler)
+			r.Post("/{uuid}/purge", adminTrashPurgeHandler)
+		})
+		r.Route("/collections", func(r chi.Router) {
+			r.Get("/", adminCollectionsHandler)
+			r.Get("/{uuid}", adminCollectionHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(requireRole(models.RoleEditor))
+				r.Post("/", adminCollectionCreateHandler)
+				r.Post("/{uuid}", adminCollectionUpdateHandler)
+				r.Post("/{uuid}/delete", adminCollectionDeleteHandler)
+				r.Post("/{uuid}/items", adminCollectionAddHandler)
+				r.Post("/{uuid}/items/{media}/delete", adminCollectionRemoveHandler)
+				r.Post("/{uuid}/order", adminCollectionOrderHandler)
+			})
 		})
 		r.Route("/cards", func(r chi.Router) {
+			r.Get("/{uuid}", adminCardsHandler)
+			r.Get("/collections/{uuid}", adminCollectionCardHandler)
+		})
+		r.Route("/account", func(r chi.Router) {
+			r.Use(requireSession)
+			r.Get("/", adminAccountHandler)
+			r.Post("/password", adminPasswordHandler)
+			r.Get("/2fa", adminTOTPHandler)
+			r.Post("/2fa", adminTOTPEnableHandler)
+			r.Post("/2fa/recovery", adminTOTPRecoveryHandler)
+			r.Post("/2fa/disable", adminTOTPDisableHandler)
+			r.Post("/sessions/revoke", adminRevokeOtherSessionsHandler)
+			r.Post("/sessions/{id}/revoke", adminRevokeSessionHandler)
+			r.Get("/tokens", adminAPITokensHandler)
+			r.Post("/tokens", adminAPITokenCreateHandler)
+			r.Post("/tokens/{id}/revoke", adminAPITokenRevokeHandler)
+		})
+		r.Route("/users", func(r chi.Router) {
+			r.Use(requireRole(models.RoleOwner))
+			r.Get("/", adminUsersHandler)
+			r.Post("/", adminUserInviteHandler)
+			r.Post("/{id}", adminUserRoleHandler)
+			r.Post("/{id}/disable", adminUserDisableHandler)
+			r.Post("/{id}/enable", adminUserEnableHandler)
+			r.Post("/{id}/unlock", adminUserUnlockHandler)
+			r.Post("/{id}/2fa/require", adminUserRequireTOTPHandler)
+			r.Post("/{id}/2fa/reset", adminUserResetTOTPHandler)
+			r.Post("/{id}/delete", adminUserDeleteHandler)
+		})
+		r.Route("/teams", func(r chi.Router) {
+			r.Use(requireRole(models.RoleOwner))
+			r.Get("/", adminTeamsHandler)
+			r.Post("/", adminTeamCreateHandler)
+			r.Post("/{id}/delete", adminTeamDeleteHandler)
+			r.Post("/{id}/members", adminTeamAddMemberHandler)
+			r.Post("/{id}/members/{user}/delete", adminTeamRemoveMemberHandler)
 		})
 	})
 
-	workDir, _ := os.Getwd()
-	filesDir := filesystem.Myfs{Dir: http.Dir(filepath.Join(workDir, "assets"))}
-	filesystem.FileServer(router, "/assets", filesDir)
+	// Uploads are read from disk and stylesheets from the embedded files
+	filesystem.FileServer(router, "/assets/media", filesystem.Myfs{Dir: http.Dir(mediaDir)})
+	css, _ := fs.Sub(files, "assets/css")
+	filesystem.FileServer(router, "/assets/css", filesystem.Myfs{Dir: http.FS(css)})
 
 }
 
 func adminAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Scripts authenticate with an API token instead of a session
+		if header := r.Header.Get("Authorization"); header != "" {
+			apiTokenAuth(next, w, r, header)
+			return
+		}
+
 		// Get the session
 		session, err := sessions.Get(r, "admin")
 		if err != nil {
@@ -92,7 +303,7 @@ func adminAuthMiddleware(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		if session.Values["user_id"] == nil {
+		if session.Values["session_id"] == nil {
 			flash.Message{
 				Title:   "Error",
 				Message: "You must be logged in to access this page",
@@ -102,31 +313,130 @@ func adminAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		// Find the user by the session
-		user, err := models.FindUserBySession(r)
+		current, err := models.FindSessionByRequest(r)
 		if err != nil {
 			// Destroy the session
 			session.Options.MaxAge = -1
 			session.Save(r, w)
 			flash.Message{
 				Title:   "Error",
-				Message: "An error occurred while trying to log in.",
+				Message: "Your session has expired. Please log in again.",
+				Style:   flash.Error,
+			}.Save(w, r)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		user := current.User
+		if user.Disabled {
+			// Destroy the session
+			session.Options.MaxAge = -1
+			session.Save(r, w)
+			flash.Message{
+				Title:   "Error",
+				Message: "Your account has been disabled.",
 				Style:   flash.Error,
 			}.Save(w, r)
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		key := userContextKey("user")
-		ctx := context.WithValue(r.Context(), key, user)
+		// Users who must use two factor authentication set it up first
+		if user.TOTPRequired && !user.TOTPEnabled && !strings.HasPrefix(r.URL.Path, "/admin/account/2fa") {
+			flash.Message{
+				Title:   "Two factor authentication required",
+				Message: "Please set up two factor authentication to continue.",
+				Style:   flash.Warning,
+			}.Save(w, r)
+			http.Redirect(w, r, "/admin/account/2fa", http.StatusSeeOther)
+			return
+		}
+		ctx := models.WithUser(r.Context(), user)
+		ctx = models.WithSession(ctx, current)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// apiTokenAuth authenticates a request with an Authorization: Bearer
+// header. GET requests need the read scope and anything else needs write.
+func apiTokenAuth(next http.Handler, w http.ResponseWriter, r *http.Request, header string) {
+	plain, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeProblem(w, r, http.StatusUnauthorized, "Use an Authorization: Bearer token")
+		return
+	}
+	token, err := models.FindAPIToken(r.Context(), strings.TrimSpace(plain))
+	if err != nil || token.User.Disabled {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin", error="invalid_token"`)
+		apiError(w, r, "", models.ErrInvalidAPIToken)
+		return
+	}
+
+	scope := models.ScopeWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		scope = models.ScopeRead
+	}
+	if !token.HasScope(scope) {
+		writeProblem(w, r, http.StatusForbidden, "This token does not have the "+string(scope)+" scope")
+		return
+	}
+
+	ctx := models.WithUser(r.Context(), token.User)
+	ctx = models.WithAPIToken(ctx, token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requireSession limits the routes to users signed in with a browser, so
+// API tokens cannot change passwords or create more tokens
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := models.SessionFromContext(r.Context()); !ok {
+			writeProblem(w, r, http.StatusForbidden, "API tokens cannot be used here")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAPIRequest reports whether the request was authenticated with an API
+// token, so handlers can respond with JSON instead of redirecting
+func isAPIRequest(r *http.Request) bool {
+	_, ok := models.APITokenFromContext(r.Context())
+	return ok
+}
+
+// requireRole limits the routes to users with at least the given role.
+// It must run after adminAuthMiddleware.
+func requireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := models.UserFromContext(r.Context())
+			if !ok || !user.HasRole(role) {
+				if isAPIRequest(r) {
+					writeProblem(w, r, http.StatusForbidden, "You do not have permission to do that")
+					return
+				}
+				flash.Message{
+					Title:   "Error",
+					Message: "You do not have permission to do that",
+					Style:   flash.Error,
+				}.Save(w, r)
+				http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func templateData(r *http.Request) map[string]interface{} {
-	ctxKey := userContextKey("user")
-	user, ok := r.Context().Value(userContextKey(ctxKey)).(*models.User)
+	user, ok := models.UserFromContext(r.Context())
 	data := map[string]interface{}{
 		"hxrequest": r.Header.Get("HX-Request") == "true",
 		"layout":    "base",
+		"csrf":      func() string { return csrfToken(r) },
+		"nonce":     cspNonce(r),
+		// Error pages quote it so the log entry can be found
+		"request_id": requestID(r),
 	}
 	if ok {
 		data["user"] = user
@@ -134,47 +444,43 @@ func templateData(r *http.Request) map[string]interface{} {
 	return data
 }
 
-func render(w http.ResponseWriter, data map[string]interface{}, admin bool, patterns ...string) error {
-	w.Header().Set("Content-Type", "text/html")
+func render(w http.ResponseWriter, data map[string]interface{}, admin bool, name string) error {
+	return renderStatus(w, http.StatusOK, data, admin, name)
+}
 
-	baseDir := "templates/public/"
-	if admin {
-		baseDir = "templates/admin/"
-	}
+// renderStatus renders a page with a status other than 200, such as an
+// error page. The status is only sent once the page has rendered, so a
+// failure can still be answered with a 500.
+func renderStatus(w http.ResponseWriter, status int, data map[string]interface{}, admin bool, name string) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	err := parse(data, baseDir, patterns...).ExecuteTemplate(w, "base", data)
-	if err != nil {
-		http.Error(w, err.Error(), 0)
-		log.Print("Template executing error: ", err)
+	key := pageKey{dir: "public", name: name}
+	if admin {
+		key.dir = "admin"
 	}
-	return err
-}
+	key.layout, _ = data["layout"].(string)
 
-func parse(data map[string]interface{}, baseDir string, patterns ...string) *template.Template {
 	// Format the title to include the app name
 	if title, ok := data["title"].(string); ok {
-		data["title"] = fmt.Sprintf("%s | %s", title, os.Getenv("APP_NAME"))
+		data["title"] = fmt.Sprintf("%s | %s", title, settings.AppName)
 	}
 
-	// Prepend the base directory to each pattern.
-	for i, pattern := range patterns {
-		patterns[i] = filepath.Join(baseDir, "pages", pattern+".html")
+	// Render into a buffer so a failure doesn't leave half a page
+	var body bytes.Buffer
+	tmpl, err := page(key)
+	if err == nil {
+		newToken, _ := data["csrf"].(func() string)
+		err = tmpl.Funcs(csrfFuncs(newToken)).ExecuteTemplate(&body, "base", data)
 	}
-
-	// Add the components dir to the patterns
-	components, err := filepath.Glob(filepath.Join(baseDir, "components", "*.html"))
 	if err != nil {
-		log.Print("Error getting components: ", err)
-	}
-	patterns = append(patterns, components...)
-
-	// Get the chosen layout
-	if layout, ok := data["layout"].(string); ok {
-		patterns = append(patterns, filepath.Join(baseDir, "layouts", layout+".html"))
+		id, _ := data["request_id"].(string)
+		log.Error("Error rendering template", "err", err, "request_id", id)
+		http.Error(w, withReference("Something went wrong", id), http.StatusInternalServerError)
+		return err
 	}
-
-	// Create a new template, add any functions, and parse the files.
-	return template.Must(template.New("base").Funcs(funcs).ParseFiles(patterns...))
+	w.WriteHeader(status)
+	_, err = body.WriteTo(w)
+	return err
 }
 
 var funcs = template.FuncMap{
@@ -187,6 +493,10 @@ var funcs = template.FuncMap{
 	"lower": func(v string) string {
 		return strings.ToLower(v)
 	},
+	// Case insensitive substring match
+	"contains": func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	},
 	"date": func(t time.Time) string {
 		if t.Year() == time.Now().Year() {
 			return t.Format("2 January")
@@ -220,26 +530,40 @@ var funcs = template.FuncMap{
 	},
 	"static": func(filename string) string {
 		filename = strings.TrimPrefix(filename, "/")
-		// get last modified time
-		file, err := os.Stat("assets/" + filename)
-
-		if err != nil {
+		version := assetVersion(filename)
+		if version == "" {
 			return "/assets/" + filename
 		}
-
-		modifiedtime := file.ModTime()
-		return "/assets/" + filename + "?v=" + modifiedtime.Format("20060102150405")
+		return "/assets/" + filename + "?v=" + version
 	},
 	// Convert a float to a duration and present it in a human readable format
 	"toDuration": func(seconds float64) string {
 		return time.Duration(int(seconds) * int(time.Second)).String()
 
 	},
-	"md": func(s string) template.HTML {
-		// Convert markdown to HTML
-		content := []byte(s)
-		return template.HTML(markdown.ToHTML(content, nil, nil))
+	// Render a URL as a QR code that can be used as an image source
+	"qrcode": func(url string) template.URL {
+		uri, err := helpers.QRCodeDataURI(url)
+		if err != nil {
+			log.Error("Error generating QR code", "err", err)
+			return ""
+		}
+		return template.URL(uri)
 	},
+	"timestamp": helpers.FormatTimestamp,
+	"md":        renderMarkdown,
+}
+
+// renderMarkdown converts a description to HTML for the public pages.
+// Editors' raw HTML is dropped and links must use a safe protocol, so they
+// cannot add scripts to the site. Custom heading IDs are written without
+// escaping, so they are turned off too.
+func renderMarkdown(s string) template.HTML {
+	p := parser.NewWithExtensions(parser.CommonExtensions &^ parser.HeadingIDs)
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags: mdhtml.CommonFlags | mdhtml.SkipHTML | mdhtml.Safelink,
+	})
+	return template.HTML(markdown.ToHTML([]byte(s), p, renderer))
 }
 
 func setDefaultHeaders(w http.ResponseWriter) {
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// unsafeHTML matches tags that could run scripts
+var unsafeHTML = regexp.MustCompile(`(?i)<(script|div|img)|<[^>]*\son\w+=|href="javascript:`)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"formatting", "**Bold** and [a link](https://example.com)", `<p><strong>Bold</strong> and <a href="https://example.com">a link</a></p>`},
+		{"code", "`<b>`", `<p><code>&lt;b&gt;</code></p>`},
+		{"script", "<script>alert(1)</script>", `<p>alert(1)</p>`},
+		{"inline HTML", "hello <img src=x onerror=alert(1)> there", `<p>hello  there</p>`},
+		{"HTML block", "<div onclick=alert(1)>\n\nblock\n\n</div>", `<p>block</p>`},
+		{"script link", "[click](javascript:alert(1))", `<p><tt>click</tt></p>`},
+		{"data link", "[click](data:text/html,<script>alert(1)</script>)", `<p><tt>click</tt></p>`},
+		{"heading ID", "# Title {#x\" onmouseover=\"alert(1)}", `<h1>Title {#x&rdquo; onmouseover=&ldquo;alert(1)}</h1>`},
+		{"block attributes", "{onclick=\"alert(1)\"}\nParagraph", `<p>{onclick=&ldquo;alert(1)&rdquo;}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(renderMarkdown(tt.markdown))
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("renderMarkdown() = %q, want it to contain %q", got, tt.want)
+			}
+			if unsafe := unsafeHTML.FindString(got); unsafe != "" {
+				t.Errorf("renderMarkdown() = %q, which contains %s", got, unsafe)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// files holds the templates and stylesheets. It is usually embedded in the
+// binary, so the server can be shipped without the templates directory.
+var files fs.FS
+
+// reloadTemplates parses the templates again for every request, so changes
+// show without restarting in development
+var reloadTemplates bool
+
+// pageKey identifies a page in one of the layouts of the admin or public
+// templates
+type pageKey struct {
+	dir    string
+	name   string
+	layout string
+}
+
+var (
+	pages  map[pageKey]*template.Template
+	emails map[string]*texttemplate.Template
+	// assetVersions caches a hash of each asset for cache busting
+	assetVersions sync.Map
+)
+
+// UseFiles serves templates and stylesheets from fsys. The templates are
+// parsed now, so a mistake stops the server from starting rather than
+// failing a request. With reload they are parsed again for every request.
+func UseFiles(fsys fs.FS, reload bool) error {
+	files = fsys
+	reloadTemplates = reload
+
+	pages = map[pageKey]*template.Template{}
+	for _, dir := range []string{"admin", "public"} {
+		names, err := fs.Glob(files, path.Join("templates", dir, "pages", "*.html"))
+		if err != nil {
+			return err
+		}
+		layouts, err := fs.Glob(files, path.Join("templates", dir, "layouts", "*.html"))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			for _, layout := range layouts {
+				key := pageKey{
+					dir:    dir,
+					name:   strings.TrimSuffix(path.Base(name), ".html"),
+					layout: strings.TrimSuffix(path.Base(layout), ".html"),
+				}
+				tmpl, err := parsePage(key)
+				if err != nil {
+					return err
+				}
+				pages[key] = tmpl
+			}
+		}
+	}
+
+	emails = map[string]*texttemplate.Template{}
+	names, err := fs.Glob(files, path.Join("templates", "email", "*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(path.Base(name), ".txt")
+		tmpl, err := parseEmail(name)
+		if err != nil {
+			return err
+		}
+		emails[name] = tmpl
+	}
+	return nil
+}
+
+// parsePage parses a page with the components and layout it is shown in.
+// The CSRF functions are placeholders until the page is rendered.
+func parsePage(key pageKey) (*template.Template, error) {
+	dir := path.Join("templates", key.dir)
+	return template.New("base").Funcs(funcs).Funcs(csrfFuncs(nil)).ParseFS(files,
+		path.Join(dir, "pages", key.name+".html"),
+		path.Join(dir, "components", "*.html"),
+		path.Join(dir, "layouts", key.layout+".html"),
+	)
+}
+
+func parseEmail(name string) (*texttemplate.Template, error) {
+	return texttemplate.ParseFS(files, path.Join("templates", "email", name+".txt"))
+}
+
+// page returns a copy of the parsed page that can be given the request's
+// CSRF token
+func page(key pageKey) (*template.Template, error) {
+	if reloadTemplates {
+		return parsePage(key)
+	}
+	tmpl, ok := pages[key]
+	if !ok {
+		return nil, fmt.Errorf("no template for page %q with layout %q", key.name, key.layout)
+	}
+	return tmpl.Clone()
+}
+
+// email returns the plain text template for an email
+func email(name string) (*texttemplate.Template, error) {
+	if reloadTemplates {
+		return parseEmail(name)
+	}
+	tmpl, ok := emails[name]
+	if !ok {
+		return nil, fmt.Errorf("no template for email %q", name)
+	}
+	return tmpl, nil
+}
+
+// assetVersion is a short hash of an asset's content, so browsers fetch it
+// again when it changes. It is empty if the asset cannot be read.
+func assetVersion(name string) string {
+	if v, ok := assetVersions.Load(name); ok && !reloadTemplates {
+		return v.(string)
+	}
+	b, err := fs.ReadFile(files, path.Join("assets", name))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	v := hex.EncodeToString(sum[:8])
+	assetVersions.Store(name, v)
+	return v
+}
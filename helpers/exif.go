@@ -0,0 +1,151 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// EXIF tags read from uploaded photos
+const (
+	exifTagOrientation      = 0x0112
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// exif holds the EXIF fields we keep on media
+type exif struct {
+	Orientation int
+	CapturedAt  time.Time
+}
+
+// jpegSegment is a marker segment from the header of a JPEG file
+type jpegSegment struct {
+	Marker byte
+	// Start and End are the offsets of the whole segment, including the marker
+	Start, End int
+	Payload    []byte
+}
+
+// jpegSegments lists the marker segments that precede the image data
+func jpegSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	segments := []jpegSegment{}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		// Start of scan: the rest of the file is image data
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segments = append(segments, jpegSegment{
+			Marker:  marker,
+			Start:   i,
+			End:     end,
+			Payload: data[i+4 : end],
+		})
+		i = end
+	}
+	return segments, nil
+}
+
+// readJPEGExif reads the EXIF fields from a JPEG file
+func readJPEGExif(data []byte) (exif, error) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return exif{}, err
+	}
+	for _, segment := range segments {
+		if segment.Marker == 0xE1 && bytes.HasPrefix(segment.Payload, []byte("Exif\x00\x00")) {
+			return parseTIFF(segment.Payload[6:])
+		}
+	}
+	return exif{}, nil
+}
+
+// parseTIFF reads the EXIF fields from a TIFF structure
+func parseTIFF(tiff []byte) (exif, error) {
+	result := exif{}
+	if len(tiff) < 8 {
+		return result, errors.New("truncated EXIF data")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return result, errors.New("invalid EXIF byte order")
+	}
+
+	ifd0, err := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	if err != nil {
+		return result, err
+	}
+
+	if entry, ok := ifd0[exifTagOrientation]; ok {
+		result.Orientation = int(order.Uint16(entry))
+	}
+	if entry, ok := ifd0[exifTagDateTime]; ok {
+		result.CapturedAt = parseExifTime(tiff, order, entry)
+	}
+	if entry, ok := ifd0[exifTagExifIFD]; ok {
+		sub, err := readIFD(tiff, order, order.Uint32(entry))
+		if err == nil {
+			if entry, ok := sub[exifTagDateTimeOriginal]; ok {
+				if t := parseExifTime(tiff, order, entry); !t.IsZero() {
+					result.CapturedAt = t
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// readIFD maps the tags of an image file directory to their raw value fields
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16][]byte, error) {
+	if int(offset)+2 > len(tiff) {
+		return nil, errors.New("invalid EXIF directory offset")
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	entries := make(map[uint16][]byte, count)
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(tiff) {
+			return nil, errors.New("truncated EXIF directory")
+		}
+		entries[order.Uint16(tiff[start:])] = tiff[start+8 : start+12]
+	}
+	return entries, nil
+}
+
+// parseExifTime reads an EXIF date, which is stored as a 20 byte string
+// elsewhere in the TIFF structure
+func parseExifTime(tiff []byte, order binary.ByteOrder, field []byte) time.Time {
+	offset := int(order.Uint32(field))
+	if offset+19 > len(tiff) {
+		return time.Time{}
+	}
+	value := strings.TrimRight(string(tiff[offset:offset+19]), "\x00 ")
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,172 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// TIFF field types used by the test EXIF
+const (
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffUndefined = 7
+)
+
+// ifdEntry is a tag in a test EXIF directory
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+	// ifd points the entry at another directory by its index
+	ifd int
+}
+
+// buildTIFF lays out the directories one after another, followed by the
+// values too long to fit in their entries
+func buildTIFF(order binary.ByteOrder, ifds ...[]ifdEntry) []byte {
+	offsets := make([]int, len(ifds))
+	size := 8
+	for i, ifd := range ifds {
+		offsets[i] = size
+		size += 2 + 12*len(ifd) + 4
+	}
+
+	tiff := make([]byte, size)
+	copy(tiff, "MM")
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], uint32(offsets[0]))
+	for i, ifd := range ifds {
+		order.PutUint16(tiff[offsets[i]:], uint16(len(ifd)))
+		for j, e := range ifd {
+			entry := tiff[offsets[i]+2+12*j:]
+			order.PutUint16(entry, e.tag)
+			order.PutUint16(entry[2:], e.typ)
+			order.PutUint32(entry[4:], e.count)
+			switch {
+			case e.ifd > 0:
+				order.PutUint32(entry[8:], uint32(offsets[e.ifd]))
+			case len(e.value) <= 4:
+				copy(entry[8:12], e.value)
+			default:
+				order.PutUint32(entry[8:], uint32(len(tiff)))
+				tiff = append(tiff, e.value...)
+			}
+		}
+	}
+	return tiff
+}
+
+// ascii returns an EXIF string entry
+func ascii(tag uint16, value string) ifdEntry {
+	return ifdEntry{tag: tag, typ: tiffASCII, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+}
+
+// photoTIFF returns the EXIF of a phone photo with the orientation, the
+// times it was taken and its location. The location and device details
+// contain the word Secret, so tests can check they were removed.
+func photoTIFF(order binary.ByteOrder, orientation int) []byte {
+	short := make([]byte, 4)
+	order.PutUint16(short, uint16(orientation))
+	return buildTIFF(order,
+		[]ifdEntry{
+			ascii(0x010F, "SecretCam"),
+			{tag: exifTagOrientation, typ: tiffShort, count: 1, value: short},
+			ascii(exifTagDateTime, "2021:03:04 05:06:07"),
+			{tag: exifTagExifIFD, typ: tiffLong, count: 1, ifd: 1},
+			{tag: 0x8825, typ: tiffLong, count: 1, ifd: 2},
+		},
+		[]ifdEntry{
+			ascii(exifTagDateTimeOriginal, "2020:01:02 03:04:05"),
+			{tag: 0x927C, typ: tiffUndefined, count: 15, value: []byte("SecretMakerNote")},
+		},
+		[]ifdEntry{
+			ascii(0x0001, "S"),
+			{tag: 0x001B, typ: tiffUndefined, count: 9, value: []byte("SecretGPS")},
+		},
+	)
+}
+
+func TestParseTIFF(t *testing.T) {
+	taken := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	modified := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name            string
+		tiff            []byte
+		wantOrientation int
+		wantCapturedAt  time.Time
+	}{
+		{"little endian", photoTIFF(binary.LittleEndian, 6), 6, taken},
+		{"big endian", photoTIFF(binary.BigEndian, 3), 3, taken},
+		{"modified time only", buildTIFF(binary.BigEndian, []ifdEntry{ascii(exifTagDateTime, "2021:03:04 05:06:07")}), 0, modified},
+		{"unreadable time", buildTIFF(binary.BigEndian, []ifdEntry{ascii(exifTagDateTime, "yesterday")}), 0, time.Time{}},
+		{"time past the end", buildTIFF(binary.BigEndian, []ifdEntry{{tag: exifTagDateTime, typ: tiffASCII, count: 20, value: []byte{0xFF, 0xFF, 0xFF, 0xFF}}}), 0, time.Time{}},
+		{"sub-directory past the end", buildTIFF(binary.BigEndian, []ifdEntry{{tag: exifTagExifIFD, typ: tiffLong, count: 1, value: []byte{0xFF, 0xFF, 0xFF, 0xFF}}}), 0, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTIFF(tt.tiff)
+			if err != nil {
+				t.Fatalf("parseTIFF() error = %v", err)
+			}
+			if got.Orientation != tt.wantOrientation || !got.CapturedAt.Equal(tt.wantCapturedAt) {
+				t.Errorf("parseTIFF() = %+v, want orientation %d taken %s", got, tt.wantOrientation, tt.wantCapturedAt)
+			}
+		})
+	}
+}
+
+func TestParseTIFFRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte("II*\x00")},
+		{"unknown byte order", []byte("XX*\x00\x08\x00\x00\x00\x00\x00")},
+		{"directory past the end", []byte("II*\x00\xFF\xFF\xFF\xFF")},
+		{"directory at the end", []byte("II*\x00\x08\x00\x00\x00")},
+		{"more entries than data", []byte("II*\x00\x08\x00\x00\x00\x05\x00\x12\x01\x03\x00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseTIFF(tt.tiff); err == nil {
+				t.Errorf("parseTIFF() = %+v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestParseTIFFTruncated(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		tiff := photoTIFF(order, 6)
+		// Every prefix must fail or parse without reading past the end
+		for n := range tiff {
+			parseTIFF(tiff[:n])
+		}
+	}
+}
+
+func TestJPEGSegmentsRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n")},
+		{"bad marker", []byte("\xFF\xD8\x00\xE1\x00\x04ab")},
+		{"length too short", []byte("\xFF\xD8\xFF\xE1\x00\x01ab")},
+		{"segment past the end", []byte("\xFF\xD8\xFF\xE1\x01\x00ab")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := jpegSegments(tt.data); err == nil {
+				t.Errorf("jpegSegments() = %+v, want an error", got)
+			}
+		})
+	}
+}
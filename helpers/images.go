@@ -0,0 +1,315 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+// ImageMetadata describes an uploaded image
+type ImageMetadata struct {
+	// Width and Height are the displayed size, after orientation is applied
+	Width       int
+	Height      int
+	Orientation int
+	CapturedAt  time.Time
+}
+
+// ReadImageMetadata reads the dimensions and EXIF metadata of an image
+func ReadImageMetadata(data []byte) (ImageMetadata, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+
+	meta := ImageMetadata{
+		Width:       config.Width,
+		Height:      config.Height,
+		Orientation: 1,
+	}
+	if format != "jpeg" {
+		return meta, nil
+	}
+
+	// Missing or unreadable EXIF is not an error; the image is still usable
+	if exif, err := readJPEGExif(data); err == nil {
+		if exif.Orientation >= 1 && exif.Orientation <= 8 {
+			meta.Orientation = exif.Orientation
+		}
+		meta.CapturedAt = exif.CapturedAt
+	}
+	// Orientations 5 to 8 rotate the image by 90 degrees
+	if meta.Orientation >= 5 {
+		meta.Width, meta.Height = meta.Height, meta.Width
+	}
+	return meta, nil
+}
+
+// ErrUnsupportedImage is returned for images whose metadata cannot be
+// stripped
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// StrippableImage reports whether StripImageMetadata can handle images of
+// the MIME type, as sniffed by http.DetectContentType
+func StrippableImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp":
+		return true
+	}
+	return false
+}
+
+// StripImageMetadata removes location, device and other embedded metadata
+// from JPEG, PNG, GIF and WebP images. JPEGs have their EXIF orientation
+// applied to the pixels first so they still display the right way up.
+// BMPs cannot carry metadata and are returned unchanged. Other formats
+// return ErrUnsupportedImage, so they are never published unstripped.
+func StripImageMetadata(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8")):
+		meta, err := ReadImageMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		if meta.Orientation > 1 {
+			return orientJPEG(data, meta.Orientation)
+		}
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNG(data)
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return stripGIF(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebP(data)
+	case bytes.HasPrefix(data, []byte("BM")):
+		return data, nil
+	}
+	return nil, ErrUnsupportedImage
+}
+
+// stripJPEG removes the EXIF, XMP, IPTC and comment segments from a JPEG
+// without re-encoding it
+func stripJPEG(data []byte) ([]byte, error) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	last := 2
+	for _, segment := range segments {
+		switch {
+		case segment.Marker == 0xE1, segment.Marker == 0xED, segment.Marker == 0xFE:
+			// APP1 (EXIF, XMP), APP13 (IPTC) and comments
+		case segment.Marker == 0xE2 && bytes.HasPrefix(segment.Payload, []byte("MPF\x00")):
+			// The index of the images after the end, which are dropped below
+		default:
+			out.Write(data[segment.Start:segment.End])
+		}
+		last = segment.End
+	}
+	// Phones can add more images, with their own EXIF, after the end of
+	// the image. Bytes of FF in the image data are followed by 00, so the
+	// first FF D9 is the end.
+	rest := data[last:]
+	if end := bytes.Index(rest, []byte{0xFF, 0xD9}); end >= 0 {
+		rest = rest[:end+2]
+	}
+	out.Write(rest)
+	return out.Bytes(), nil
+}
+
+// stripPNG removes the EXIF and text chunks from a PNG, and anything after
+// the end of the image
+func stripPNG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+	i := 8
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		case "IEND":
+			out.Write(data[i:end])
+			return out.Bytes(), nil
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return nil, errors.New("PNG has no end chunk")
+}
+
+// stripGIF removes the comment extensions and the application extensions,
+// which can carry XMP, from a GIF. The looping extensions used by
+// animations are kept.
+func stripGIF(data []byte) ([]byte, error) {
+	errInvalid := errors.New("invalid GIF")
+	if len(data) < 13 {
+		return nil, errInvalid
+	}
+	i := 13
+	// Global colour table
+	if data[10]&0x80 != 0 {
+		i += 3 << (int(data[10]&0x07) + 1)
+	}
+	if i > len(data) {
+		return nil, errInvalid
+	}
+
+	// skipSubBlocks returns the index after the sub-blocks starting at j
+	skipSubBlocks := func(j int) (int, error) {
+		for j < len(data) {
+			size := int(data[j])
+			j += 1 + size
+			if size == 0 {
+				return j, nil
+			}
+		}
+		return 0, errInvalid
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:i])
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3B:
+			// Trailer
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errInvalid
+			}
+			label := data[i+1]
+			end, err := skipSubBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := true
+			if label == 0xFE {
+				keep = false
+			} else if label == 0xFF {
+				app := data[i+2 : end]
+				keep = bytes.HasPrefix(app, []byte("\x0BNETSCAPE2.0")) ||
+					bytes.HasPrefix(app, []byte("\x0BANIMEXTS1.0"))
+			}
+			if keep {
+				out.Write(data[start:end])
+			}
+			i = end
+		case 0x2C:
+			// Image descriptor, local colour table and image data
+			if i+10 > len(data) {
+				return nil, errInvalid
+			}
+			j := i + 10
+			if data[i+9]&0x80 != 0 {
+				j += 3 << (int(data[i+9]&0x07) + 1)
+			}
+			// LZW minimum code size
+			j++
+			end, err := skipSubBlocks(j)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(data[start:end])
+			i = end
+		default:
+			return nil, errInvalid
+		}
+	}
+	return nil, errInvalid
+}
+
+// stripWebP removes the EXIF and XMP chunks from a WebP and clears the
+// flags that announce them
+func stripWebP(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			return nil, errors.New("invalid WebP")
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if size > 0 {
+				// The EXIF and XMP flags
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, nil
+}
+
+// orientJPEG re-encodes a JPEG with its EXIF orientation applied.
+// Re-encoding also drops every metadata segment.
+func orientJPEG(data []byte, orientation int) ([]byte, error) {
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Mirrored and rotated 270
+				dx, dy = y, x
+			case 6: // Rotated 90
+				dx, dy = h-1-y, x
+			case 7: // Mirrored and rotated 90
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 270
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if err := jpeg.Encode(out, dst, &jpeg.Options{Quality: 92}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
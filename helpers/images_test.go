@@ -0,0 +1,363 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// secrets are the metadata values the fixtures carry, which stripping must
+// remove
+var secrets = []string{"SecretCam", "SecretMakerNote", "SecretGPS", "SecretXMP", "SecretIPTC", "SecretComment"}
+
+// testImage returns an image with a different colour in each pixel
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 60), uint8(y * 60), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegMarker returns a JPEG marker segment
+func jpegMarker(marker byte, payload string) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJPEG encodes a JPEG with the segments added after the start marker
+func testJPEG(t *testing.T, w, h int, segments ...[]byte) []byte {
+	t.Helper()
+	encoded := &bytes.Buffer{}
+	if err := jpeg.Encode(encoded, testImage(w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{}, encoded.Bytes()[:2]...)
+	for _, segment := range segments {
+		data = append(data, segment...)
+	}
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+// pngChunk returns a PNG chunk with its checksum
+func pngChunk(kind, payload string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// testPNG encodes a PNG with the chunks added after the header
+func testPNG(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	encoded := &bytes.Buffer{}
+	if err := png.Encode(encoded, testImage(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+	// The signature and the IHDR chunk
+	header := 8 + 12 + 13
+	data := append([]byte{}, encoded.Bytes()[:header]...)
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	return append(data, encoded.Bytes()[header:]...)
+}
+
+// gifExtension returns a GIF extension with the data in sub-blocks
+func gifExtension(label byte, header, payload string) []byte {
+	ext := []byte{0x21, label}
+	if header != "" {
+		ext = append(ext, byte(len(header)))
+		ext = append(ext, header...)
+	}
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > 255 {
+			n = 255
+		}
+		ext = append(ext, byte(n))
+		ext = append(ext, payload[:n]...)
+		payload = payload[n:]
+	}
+	return append(ext, 0)
+}
+
+// testGIF encodes a looping animation with the extensions added before the
+// first frame
+func testGIF(t *testing.T, extensions ...[]byte) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	frame := image.NewPaletted(image.Rect(0, 0, 3, 2), palette)
+	frame.SetColorIndex(1, 1, 1)
+	encoded := &bytes.Buffer{}
+	err := gif.EncodeAll(encoded, &gif.GIF{
+		Image: []*image.Paletted{frame, frame},
+		Delay: []int{10, 10},
+		Config: image.Config{
+			ColorModel: palette,
+			Width:      3,
+			Height:     2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := encoded.Bytes()
+	header := 13
+	if raw[10]&0x80 != 0 {
+		header += 3 << (int(raw[10]&0x07) + 1)
+	}
+	data := append([]byte{}, raw[:header]...)
+	for _, ext := range extensions {
+		data = append(data, ext...)
+	}
+	return append(data, raw[header:]...)
+}
+
+// webpChunk returns a RIFF chunk, padded to an even length
+func webpChunk(kind, payload string) []byte {
+	chunk := append([]byte(kind), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpPixel is the image data of a lossless 1x1 WebP
+const webpPixel = "\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"
+
+// testWebP returns a WebP file made of the chunks
+func testWebP(chunks ...[]byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+// webpExtended returns the VP8X chunk announcing EXIF and XMP
+func webpExtended() []byte {
+	return webpChunk("VP8X", "\x0C\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+}
+
+const (
+	xmp  = "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>SecretXMP</x:xmpmeta>"
+	iptc = "Photoshop 3.0\x008BIM\x04\x04SecretIPTC"
+)
+
+func TestStripImageMetadata(t *testing.T) {
+	exif := "Exif\x00\x00" + string(photoTIFF(binary.BigEndian, 1))
+	tiff := string(photoTIFF(binary.LittleEndian, 1))
+	// A second image after the end, as phones add for depth or previews
+	secondary := testJPEG(t, 2, 2, jpegMarker(0xE1, exif))
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFormat string
+		wantWidth  int
+		wantHeight int
+	}{
+		{"JPEG", testJPEG(t, 3, 2,
+			jpegMarker(0xE1, exif),
+			jpegMarker(0xE1, xmp),
+			jpegMarker(0xED, iptc),
+			jpegMarker(0xFE, "SecretComment"),
+		), "jpeg", 3, 2},
+		{"JPEG with more images", append(testJPEG(t, 3, 2, jpegMarker(0xE2, "MPF\x00SecretCam")), secondary...), "jpeg", 3, 2},
+		{"PNG", testPNG(t,
+			pngChunk("eXIf", tiff),
+			pngChunk("tEXt", "Comment\x00SecretComment"),
+			pngChunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmp),
+			pngChunk("zTXt", "Author\x00\x00SecretCam"),
+		), "png", 3, 2},
+		{"PNG with data after the end", append(testPNG(t), pngChunk("tEXt", "Comment\x00SecretComment")...), "png", 3, 2},
+		{"GIF", testGIF(t,
+			gifExtension(0xFE, "", "SecretComment"),
+			gifExtension(0xFF, "XMP DataXMP", xmp),
+			gifExtension(0xFF, "SECRETAPP1.0", "SecretCam"),
+		), "gif", 3, 2},
+		{"WebP", testWebP(
+			webpExtended(),
+			webpChunk("VP8L", webpPixel),
+			webpChunk("EXIF", tiff),
+			webpChunk("XMP ", xmp),
+		), "webp", 1, 1},
+		{"simple WebP", testWebP(webpChunk("VP8L", webpPixel), webpChunk("EXIF", exif)), "webp", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StripImageMetadata(tt.data)
+			if err != nil {
+				t.Fatalf("StripImageMetadata() error = %v", err)
+			}
+			for _, secret := range secrets {
+				if bytes.Contains(got, []byte(secret)) {
+					t.Errorf("stripped image still contains %s", secret)
+				}
+			}
+
+			img, format, err := image.Decode(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("stripped image does not decode: %v", err)
+			}
+			if format != tt.wantFormat || img.Bounds().Dx() != tt.wantWidth || img.Bounds().Dy() != tt.wantHeight {
+				t.Errorf("stripped image is a %dx%d %s, want a %dx%d %s",
+					img.Bounds().Dx(), img.Bounds().Dy(), format, tt.wantWidth, tt.wantHeight, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestStripImageMetadataKeepsImageData(t *testing.T) {
+	// Without metadata the JPEG is copied rather than re-encoded
+	plain := testJPEG(t, 3, 2)
+	got, err := StripImageMetadata(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Error("stripping a JPEG without metadata changed it")
+	}
+
+	// Animations keep looping
+	got, err = StripImageMetadata(testGIF(t, gifExtension(0xFE, "", "SecretComment")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	animation, err := gif.DecodeAll(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != 2 || animation.LoopCount != 0 {
+		t.Errorf("stripped GIF has %d frames and loop count %d, want 2 frames looping forever", len(animation.Image), animation.LoopCount)
+	}
+
+	// WebP flags no longer announce the removed chunks
+	got, err = StripImageMetadata(testWebP(webpExtended(), webpChunk("VP8L", webpPixel), webpChunk("EXIF", "SecretCam")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[20] != 0 {
+		t.Errorf("VP8X flags = %#x, want 0", got[20])
+	}
+	if size := binary.LittleEndian.Uint32(got[4:]); int(size) != len(got)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(got)-8)
+	}
+}
+
+func TestStripImageMetadataOrientsJPEG(t *testing.T) {
+	// Rotated 90 degrees
+	data := testJPEG(t, 3, 2, jpegMarker(0xE1, "Exif\x00\x00"+string(photoTIFF(binary.LittleEndian, 6))))
+
+	got, err := StripImageMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets {
+		if bytes.Contains(got, []byte(secret)) {
+			t.Errorf("oriented image still contains %s", secret)
+		}
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 2 || config.Height != 3 {
+		t.Errorf("oriented image is %dx%d, want 2x3", config.Width, config.Height)
+	}
+}
+
+func TestStripImageMetadataRejectsMalformed(t *testing.T) {
+	pixel := webpChunk("VP8L", webpPixel)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"TIFF", []byte("II*\x00\x08\x00\x00\x00")},
+		{"HEIC", []byte("\x00\x00\x00\x18ftypheic")},
+		{"empty", nil},
+		{"JPEG with a bad segment", []byte("\xFF\xD8\xFF\xE1\x01\x00ab")},
+		{"PNG chunk past the end", append(testPNG(t)[:33], "\x00\x00\x10\x00tEXtSecretComment"...)},
+		{"PNG without an end", testPNG(t)[:33]},
+		{"GIF without frames", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00")},
+		{"GIF with an unknown block", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00\x99;")},
+		{"GIF colour table past the end", []byte("GIF89a\x01\x00\x01\x00\xF7\x00\x00")},
+		{"WebP chunk past the end", testWebP(pixel, []byte("EXIF\xFF\x00\x00\x00Secret"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := StripImageMetadata(tt.data); err == nil {
+				t.Errorf("StripImageMetadata() = %d bytes, want an error", len(got))
+			}
+		})
+	}
+
+	if _, err := StripImageMetadata([]byte("II*\x00")); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("StripImageMetadata(TIFF) error = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestStripImageMetadataTruncated(t *testing.T) {
+	exif := "Exif\x00\x00" + string(photoTIFF(binary.BigEndian, 1))
+	fixtures := map[string][]byte{
+		"JPEG": testJPEG(t, 3, 2, jpegMarker(0xE1, exif), jpegMarker(0xFE, "SecretComment")),
+		"PNG":  testPNG(t, pngChunk("tEXt", "Comment\x00SecretComment")),
+		"GIF":  testGIF(t, gifExtension(0xFE, "", "SecretComment")),
+		"WebP": testWebP(webpExtended(), webpChunk("VP8L", webpPixel), webpChunk("EXIF", exif)),
+	}
+	for name, data := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			// Every prefix must fail, or succeed without the metadata
+			for n := range data {
+				got, err := StripImageMetadata(data[:n])
+				if err != nil {
+					continue
+				}
+				for _, secret := range secrets {
+					if bytes.Contains(got, []byte(secret)) {
+						t.Fatalf("the first %d bytes stripped still contain %s", n, secret)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadImageMetadata(t *testing.T) {
+	data := testJPEG(t, 3, 2, jpegMarker(0xE1, "Exif\x00\x00"+string(photoTIFF(binary.BigEndian, 8))))
+
+	got, err := ReadImageMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ImageMetadata{Width: 2, Height: 3, Orientation: 8, CapturedAt: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if got != want {
+		t.Errorf("ReadImageMetadata() = %+v, want %+v", got, want)
+	}
+
+	// Broken EXIF is ignored
+	data = testJPEG(t, 3, 2, jpegMarker(0xE1, "Exif\x00\x00MM\x00*\xFF\xFF\xFF\xFF"))
+	got, err = ReadImageMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImageMetadata{Width: 3, Height: 2, Orientation: 1}); got != want {
+		t.Errorf("ReadImageMetadata() = %+v, want %+v", got, want)
+	}
+}
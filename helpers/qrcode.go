@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/yeqown/go-qrcode/v2"
+	"github.com/yeqown/go-qrcode/writer/standard"
+)
+
+// nopCloser lets a buffer satisfy the io.WriteCloser the QR writer expects
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// QRCode renders the given text as a PNG QR code
+func QRCode(text string) ([]byte, error) {
+	qr, err := qrcode.New(text)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := nopCloser{&bytes.Buffer{}}
+	w := standard.NewWithWriter(buf,
+		standard.WithBuiltinImageEncoder(standard.PNG_FORMAT),
+		standard.WithQRWidth(10),
+		standard.WithBorderWidth(20),
+	)
+	if err := qr.Save(w); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// QRCodeDataURI renders the given text as a QR code embedded in a data URI
+func QRCodeDataURI(text string) (string, error) {
+	png, err := QRCode(text)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxTimestamp is the latest position accepted, well past the end of any
+// lecture recording
+const maxTimestamp = 24 * 60 * 60
+
+// ParseTimestamp converts a playback position into seconds.
+// It accepts plain seconds ("270"), clock notation ("4:30", "1:04:30")
+// and Go durations ("4m30s").
+func ParseTimestamp(s string) (float64, error) {
+	seconds, err := parseTimestamp(s)
+	if err != nil {
+		return 0, err
+	}
+	// ParseFloat accepts NaN, Inf and huge exponents
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > maxTimestamp {
+		return 0, fmt.Errorf("invalid timestamp: %s", strings.TrimSpace(s))
+	}
+	return seconds, nil
+}
+
+func parseTimestamp(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty timestamp")
+	}
+
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		if seconds < 0 {
+			return 0, errors.New("timestamp cannot be negative")
+		}
+		return seconds, nil
+	}
+
+	if strings.Contains(s, ":") {
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("invalid timestamp: %s", s)
+		}
+		var seconds float64
+		for _, part := range parts {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid timestamp: %s", s)
+			}
+			seconds = seconds*60 + n
+		}
+		return seconds, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timestamp: %s", s)
+	}
+	return d.Seconds(), nil
+}
+
+// FormatTimestamp formats seconds in clock notation, e.g. 4:30 or 1:04:30
+func FormatTimestamp(seconds float64) string {
+	total := int(seconds)
+	h, m, s := total/3600, (total/60)%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
@@ -1,15 +1,30 @@
 package helpers
 
 import (
+	"net/http"
 	"net/url"
-	"os"
+	"strings"
+
+	"github.com/go-chi/chi"
 )
 
+// secretParams are the route parameters that carry a secret, such as the
+// token in a password reset link
+var secretParams = []string{"token"}
+
+// siteURL is the public address of the site, set by SetSiteURL
+var siteURL string
+
+// SetSiteURL sets the public address that URL builds on
+func SetSiteURL(site string) {
+	siteURL = site
+}
+
 // URL constructs a URL specific to the application
 func URL(patterns ...string) string {
 	u := &url.URL{}
-	if site, ok := os.LookupEnv("SITE_URL"); ok {
-		u, _ = url.Parse(site)
+	if siteURL != "" {
+		u, _ = url.Parse(siteURL)
 	} else {
 		u.Path = "/"
 	}
@@ -24,3 +39,26 @@ func URL(patterns ...string) string {
 	}
 	return u.String()
 }
+
+// RedactedPath returns the path of a routed request with any secret route
+// parameters hidden, so it can be logged and traced. Links to reset a
+// password or accept an invite stay usable until they are spent.
+func RedactedPath(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return r.URL.Path
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	for _, key := range secretParams {
+		value := rctx.URLParam(key)
+		if value == "" {
+			continue
+		}
+		for i, segment := range segments {
+			if segment == value {
+				segments[i] = "{" + key + "}"
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
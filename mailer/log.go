@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// LogMailer writes messages to the log instead of sending them. When Dir is
+// set, each message is also written to a file there.
+type LogMailer struct {
+	Dir string
+	// LogBody adds the body to the log. Bodies can hold live links, such as
+	// for password resets, so this is only for development.
+	LogBody bool
+}
+
+// Send logs the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	logger := log.FromContext(ctx)
+	if m.LogBody {
+		logger.Info("Email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	} else {
+		logger.Info("Email", "to", msg.To, "subject", msg.Subject)
+	}
+	if m.Dir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	to := strings.NewReplacer("/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.txt", time.Now().Format("20060102-150405.000000000"), to)
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0644)
+}
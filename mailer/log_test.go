@@ -0,0 +1,116 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+)
+
+const resetLink = "https://example.com/reset/secret-token"
+
+func testMessage() Message {
+	return Message{
+		To:      "user@example.com",
+		Subject: "Reset your password",
+		Body:    "Follow this link to reset your password:\n" + resetLink + "\n",
+	}
+}
+
+// logContext returns a context whose logger writes to the buffer
+func logContext(buf *bytes.Buffer) context.Context {
+	return log.WithContext(context.Background(), log.New(buf))
+}
+
+func TestLogMailerLogsBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		logBody bool
+	}{
+		{"production", false},
+		{"development", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m := &LogMailer{LogBody: tt.logBody}
+			err := m.Send(logContext(&buf), testMessage())
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			out := buf.String()
+			for _, want := range []string{"user@example.com", "Reset your password"} {
+				if !strings.Contains(out, want) {
+					t.Errorf("log %q does not contain %q", out, want)
+				}
+			}
+			if got := strings.Contains(out, resetLink); got != tt.logBody {
+				t.Errorf("log contains the body = %v, want %v\n%s", got, tt.logBody, out)
+			}
+		})
+	}
+}
+
+func TestLogMailerWritesFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &LogMailer{Dir: dir}
+	msg := testMessage()
+	msg.To = "../user/name@example.com"
+
+	var buf bytes.Buffer
+	err := m.Send(logContext(&buf), msg)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading the mail directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d files, want 1", len(entries))
+	}
+	name := entries[0].Name()
+	if !strings.HasSuffix(name, "-.._user_name@example.com.txt") {
+		t.Errorf("file name %q does not end with the escaped recipient", name)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "To: ../user/name@example.com\nSubject: Reset your password\n\n" + msg.Body
+	if string(content) != want {
+		t.Errorf("file content = %q, want %q", content, want)
+	}
+}
+
+func TestLogMailerWithoutDir(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var buf bytes.Buffer
+	err = (&LogMailer{}).Send(logContext(&buf), testMessage())
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("wrote %d files without a directory, want none", len(entries))
+	}
+}
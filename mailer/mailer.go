@@ -0,0 +1,53 @@
+// Package mailer sends email through a configurable backend.
+//
+// The smtp driver sends through an SMTP server. The log driver, the
+// default, writes each message to the log and, when a directory is set,
+// to a file in that directory for development and tests.
+package mailer
+
+import (
+	"context"
+
+	"github.com/nathanhollows/ace-video/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var mailer Mailer = &LogMailer{}
+
+// Start configures the mailer. The settings are validated by the config
+// package. The log driver only logs message bodies in development.
+func Start(c config.Mail, development bool) {
+	switch c.Driver {
+	case "smtp":
+		mailer = &SMTPMailer{
+			Host:     c.SMTPHost,
+			Port:     c.SMTPPort,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			From:     c.From,
+		}
+	default:
+		mailer = &LogMailer{Dir: c.Dir, LogBody: development}
+	}
+}
+
+// Use replaces the mailer, such as with a fake in tests
+func Use(m Mailer) {
+	mailer = m
+}
+
+// Send delivers the message with the configured mailer
+func Send(ctx context.Context, msg Message) error {
+	return mailer.Send(ctx, msg)
+}
@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"testing"
+
+	"github.com/nathanhollows/ace-video/config"
+)
+
+func TestStartChoosesDriver(t *testing.T) {
+	defer Use(&LogMailer{})
+
+	Start(config.Mail{Driver: "smtp", SMTPHost: "mail.example.com", SMTPPort: "587", From: "ace@example.com"}, true)
+	if _, ok := mailer.(*SMTPMailer); !ok {
+		t.Errorf("smtp driver gave %T, want *SMTPMailer", mailer)
+	}
+
+	Start(config.Mail{Dir: "mail"}, true)
+	logMailer, ok := mailer.(*LogMailer)
+	if !ok {
+		t.Fatalf("default driver gave %T, want *LogMailer", mailer)
+	}
+	if logMailer.Dir != "mail" || !logMailer.LogBody {
+		t.Errorf("log mailer = %+v, want the directory and bodies logged in development", logMailer)
+	}
+
+	Start(config.Mail{}, false)
+	if mailer.(*LogMailer).LogBody {
+		t.Error("log mailer logs bodies outside development")
+	}
+}
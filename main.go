@@ -3,15 +3,125 @@
 package main
 
 import (
-	"github.com/joho/godotenv"
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	stdlog "log"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/config"
 	"github.com/nathanhollows/ace-video/handlers"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/mailer"
 	"github.com/nathanhollows/ace-video/models"
+	"github.com/nathanhollows/ace-video/oidc"
+	"github.com/nathanhollows/ace-video/ratelimit"
 	"github.com/nathanhollows/ace-video/sessions"
+	"github.com/nathanhollows/ace-video/tracing"
 )
 
+// files are the templates and stylesheets, embedded so the binary can be
+// shipped on its own
+//
+//go:embed templates assets/css
+var files embed.FS
+
 func main() {
-	godotenv.Load(".env")
-	models.InitDB()
-	sessions.Start()
-	handlers.Start()
+	c, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration:\n" + err.Error())
+	}
+	configureLogging(c.Log)
+	for _, warning := range c.Warnings() {
+		log.Warn(warning)
+	}
+	helpers.SetSiteURL(c.SiteURL)
+	models.InitDB(c)
+
+	// ace-video setup-token prints a new link for creating the first user
+	if len(args) > 0 && args[0] == "setup-token" {
+		link, err := setupLink()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(link)
+		return
+	}
+
+	// Development reads the files from disk so changes show on reload
+	var ui fs.FS = files
+	if c.Development {
+		ui = os.DirFS(".")
+	}
+	if err := handlers.UseFiles(ui, c.Development); err != nil {
+		log.Fatal("Error parsing templates", "err", err)
+	}
+
+	sessions.Start(c.SessionKey)
+	mailer.Start(c.Mail, c.Development)
+	oidc.Start(c.OIDC)
+	if err := ratelimit.TrustProxies(c.Security.TrustedProxies); err != nil {
+		log.Fatal("Error reading the trusted proxies", "err", err)
+	}
+	if err := tracing.Start(c.Tracing); err != nil {
+		log.Fatal("Error starting tracing", "err", err)
+	}
+
+	// Only the holder of the link can create the first user
+	link, err := setupLink()
+	if err == nil {
+		log.Warn("No users exist. Open this link to create the owner account", "url", link)
+	} else if !errors.Is(err, models.ErrAlreadySetUp) {
+		log.Error("Error creating setup link", "err", err)
+	}
+
+	models.StartTrashPurge()
+
+	err = handlers.Start(c)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		log.Error("Error sending the remaining traces", "err", err)
+	}
+	if err := models.CloseDB(); err != nil {
+		log.Error("Error closing the database", "err", err)
+	}
+	if err != nil {
+		log.Fatal("Server stopped", "err", err)
+	}
+}
+
+// configureLogging sets the format and level of the log. The standard
+// library logger, which net/http and some dependencies use, writes
+// through it too.
+func configureLogging(c config.Log) {
+	level, _ := log.ParseLevel(c.Level)
+	log.SetLevel(level)
+	switch c.Format {
+	case "json":
+		log.SetFormatter(log.JSONFormatter)
+		log.SetTimeFormat(time.RFC3339)
+	case "logfmt":
+		log.SetFormatter(log.LogfmtFormatter)
+		log.SetTimeFormat(time.RFC3339)
+	}
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.StandardLog().Writer())
+}
+
+// setupLink issues a setup token and returns the link to use it
+func setupLink() (string, error) {
+	token, err := models.NewSetupToken(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return helpers.URL("/setup", "token="+token), nil
 }
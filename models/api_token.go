@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+)
+
+// APIScope limits what an API token can do
+type APIScope string
+
+const (
+	// ScopeRead allows GET requests
+	ScopeRead APIScope = "read"
+	// ScopeWrite allows everything else, such as uploading and tagging
+	ScopeWrite APIScope = "write"
+)
+
+// APIScopes lists every scope
+var APIScopes = []APIScope{ScopeRead, ScopeWrite}
+
+// apiTokenPrefix marks API tokens so they are easy to spot in scripts and
+// secret scanners
+const apiTokenPrefix = "ace_"
+
+// APIToken lets scripts use the admin with an Authorization: Bearer header.
+// It acts as the user who created it, limited to its scopes.
+// Only a hash of the token is stored.
+type APIToken struct {
+	ID         string    `bun:",pk,type:varchar(36)" json:"id"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UserID     string    `bun:",notnull,type:varchar(36)" json:"-"`
+	User       *User     `bun:"rel:belongs-to,join:user_id=id" json:"-"`
+	Name       string    `bun:",type:varchar(255)" json:"name"`
+	Prefix     string    `bun:",type:varchar(16)" json:"prefix"`
+	Hash       string    `bun:",unique,type:varchar(64)" json:"-"`
+	Scopes     string    `bun:",type:varchar(255)" json:"scopes"`
+	LastUsedAt time.Time `bun:",nullzero" json:"last_used_at"`
+}
+
+type APITokens []*APIToken
+
+var ErrInvalidAPIToken = NewUnauthorizedError("invalid API token")
+
+// NewAPIToken creates a token for the user. It returns the token, which is
+// only shown once.
+func NewAPIToken(ctx context.Context, userID, name string, scopes []APIScope) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", NewUserError("tokens need a name")
+	}
+	if len(scopes) == 0 {
+		return "", NewUserError("tokens need at least one scope")
+	}
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return "", NewUserError("unknown scope: " + string(scope))
+		}
+		names[i] = string(scope)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	plain := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	token := &APIToken{
+		ID:     uuid.New().String(),
+		UserID: userID,
+		Name:   strings.TrimSpace(name),
+		Prefix: plain[:len(apiTokenPrefix)+6],
+		Hash:   hashToken(plain),
+		Scopes: strings.Join(names, ","),
+	}
+	_, err := db.NewInsert().Model(token).Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// FindAPIToken finds the token and its user, and records that it was used
+func FindAPIToken(ctx context.Context, plain string) (*APIToken, error) {
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+	token := &APIToken{}
+	err := db.NewSelect().
+		Model(token).
+		Relation("User").
+		Where("api_token.hash = ?", hashToken(plain)).
+		Scan(ctx)
+	if err != nil || token.User == nil {
+		return nil, ErrInvalidAPIToken
+	}
+
+	if time.Since(token.LastUsedAt) > lastSeenInterval {
+		token.LastUsedAt = time.Now()
+		_, err = db.NewUpdate().
+			Model(token).
+			Column("last_used_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error("Error updating API token", "err", err)
+		}
+	}
+	return token, nil
+}
+
+// FindUserAPITokens finds the user's tokens, newest first
+func FindUserAPITokens(ctx context.Context, userID string) (APITokens, error) {
+	tokens := APITokens{}
+	err := db.NewSelect().
+		Model(&tokens).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// FindUserAPITokenByID finds one of the user's tokens
+func FindUserAPITokenByID(ctx context.Context, userID, id string) (*APIToken, error) {
+	token := &APIToken{}
+	err := db.NewSelect().
+		Model(token).
+		Where("id = ?", id).
+		Where("user_id = ?", userID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Revoke deletes the token so it can no longer be used
+func (t *APIToken) Revoke(ctx context.Context) error {
+	_, err := db.NewDelete().
+		Model((*APIToken)(nil)).
+		Where("id = ?", t.ID).
+		Exec(ctx)
+	return err
+}
+
+// HasScope reports whether the token was granted the scope
+func (t *APIToken) HasScope(scope APIScope) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if APIScope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
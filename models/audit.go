@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete"
+	AuditRestore AuditAction = "restore"
+)
+
+// FieldChange is the value of a field before and after a change
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// AuditChanges maps each changed column to its change
+type AuditChanges map[string]FieldChange
+
+// AuditEntry records a change made to a media item or one of its tags
+type AuditEntry struct {
+	ID         string       `bun:",pk,type:varchar(36)" json:"id"`
+	CreatedAt  time.Time    `bun:",nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UserID     string       `bun:",type:varchar(36)" json:"user_id"`
+	User       *User        `bun:"rel:belongs-to,join:user_id=id" json:"-"`
+	MediaID    string       `bun:",notnull,type:varchar(36)" json:"media_id"`
+	EntityType string       `bun:",type:varchar(16)" json:"entity_type"`
+	EntityID   string       `bun:",type:varchar(36)" json:"entity_id"`
+	Action     AuditAction  `bun:",type:varchar(16)" json:"action"`
+	Changes    AuditChanges `bun:",type:text" json:"changes"`
+}
+
+type AuditEntries []*AuditEntry
+
+// auditable is a model whose changes are recorded in the audit trail
+type auditable interface {
+	// auditTarget identifies the model and the media it belongs to
+	auditTarget() (entityType, entityID, mediaID string)
+	// auditFields lists the columns whose changes are recorded
+	auditFields() []string
+}
+
+func (m *Media) auditTarget() (string, string, string) {
+	return "media", m.ID, m.ID
+}
+
+func (m *Media) auditFields() []string {
+	return []string{"title", "description", "caption", "file_name", "mime_type", "file_path", "keep_metadata"}
+}
+
+func (t *Tag) auditTarget() (string, string, string) {
+	return "tag", t.ID, t.MediaID
+}
+
+func (t *Tag) auditFields() []string {
+	return []string{"name"}
+}
+
+// recordAudit saves an audit entry for a change to a model.
+// before is nil for a create and after is nil for a delete.
+// Updates that do not change any recorded field are skipped.
+func recordAudit(ctx context.Context, idb bun.IDB, action AuditAction, before, after auditable) error {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	entityType, entityID, mediaID := subject.auditTarget()
+
+	changes := AuditChanges{}
+	for _, field := range subject.auditFields() {
+		var change FieldChange
+		if before != nil {
+			change.From = auditValue(before, field)
+		}
+		if after != nil {
+			change.To = auditValue(after, field)
+		}
+		if change.From != change.To {
+			changes[field] = change
+		}
+	}
+	if action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	entry := &AuditEntry{
+		ID:         uuid.New().String(),
+		MediaID:    mediaID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Changes:    changes,
+	}
+	if user, ok := UserFromContext(ctx); ok {
+		entry.UserID = user.ID
+	}
+	_, err := idb.NewInsert().Model(entry).Exec(ctx)
+	return err
+}
+
+// auditValue formats a column of a model for the audit trail
+func auditValue(model auditable, column string) string {
+	v := reflect.ValueOf(model).Elem()
+	field, err := db.Table(v.Type()).Field(column)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(field.Value(v).Interface())
+}
+
+// FindAuditEntries finds the history of a media item, newest first
+func FindAuditEntries(ctx context.Context, mediaID string) (AuditEntries, error) {
+	entries := AuditEntries{}
+	err := db.NewSelect().
+		Model(&entries).
+		Relation("User").
+		Where("audit_entry.media_id = ?", mediaID).
+		Order("audit_entry.created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// FindAuditEntryByID finds an entry in the history of a media item
+func FindAuditEntryByID(ctx context.Context, mediaID, id string) (*AuditEntry, error) {
+	entry := &AuditEntry{}
+	err := db.NewSelect().
+		Model(entry).
+		Where("id = ?", id).
+		Where("media_id = ?", mediaID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// fileColumns are the media columns that describe its file. The previous
+// file is moved to the versions folder when it is replaced, so these
+// changes are undone by rolling back to a version instead.
+var fileColumns = []string{"file_name", "mime_type", "file_path"}
+
+// ChangesFile reports whether the change replaced the media's file
+func (e *AuditEntry) ChangesFile() bool {
+	if e.EntityType != "media" {
+		return false
+	}
+	for _, column := range fileColumns {
+		if _, ok := e.Changes[column]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRevert reports whether the change can be undone
+func (e *AuditEntry) CanRevert() bool {
+	switch {
+	case e.EntityType == "media" && e.Action == AuditUpdate:
+		return !e.ChangesFile()
+	case e.EntityType == "media" && e.Action == AuditDelete:
+		return true
+	case e.EntityType == "tag" && (e.Action == AuditCreate || e.Action == AuditDelete):
+		return true
+	}
+	return false
+}
+
+// Revert undoes the change. The revert is itself recorded as a new change.
+func (e *AuditEntry) Revert(ctx context.Context) error {
+	if !e.CanRevert() {
+		return NewConflictError("this change cannot be reverted")
+	}
+
+	switch e.EntityType {
+	case "media":
+		if e.Action == AuditDelete {
+			media, err := FindDeletedMediaByID(ctx, e.EntityID)
+			if err != nil {
+				return err
+			}
+			return media.Restore(ctx)
+		}
+
+		media, err := FindMediaByID(ctx, e.EntityID)
+		if err != nil {
+			return err
+		}
+		v := reflect.ValueOf(media).Elem()
+		table := db.Table(v.Type())
+		for column, change := range e.Changes {
+			field, err := table.Field(column)
+			if err != nil {
+				return err
+			}
+			err = field.ScanValue(v, change.From)
+			if err != nil {
+				return err
+			}
+		}
+		// The public copy must match the metadata setting
+		if _, ok := e.Changes["keep_metadata"]; ok && media.Type() == "image" {
+			err = media.ProcessImage(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return media.Save(ctx)
+
+	case "tag":
+		tag := &Tag{
+			ID:      e.EntityID,
+			MediaID: e.MediaID,
+		}
+		if e.Action == AuditCreate {
+			tag.Name = e.Changes["name"].To
+			return tag.Delete(ctx)
+		}
+		tag.Name = e.Changes["name"].From
+		return tag.Save(ctx)
+	}
+	return nil
+}
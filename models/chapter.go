@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/helpers"
+)
+
+type Chapter struct {
+	baseModel
+
+	ID          string  `bun:",pk,type:varchar(36)" json:"id"`
+	MediaID     string  `bun:",notnull,type:varchar(36)" json:"-"`
+	Media       *Media  `bun:"rel:belongs-to,join:media_id=id" json:"-"`
+	Start       float64 `bun:",notnull" json:"start"`
+	Title       string  `bun:",type:varchar(255)" json:"title"`
+	Description string  `bun:",type:text" json:"description"`
+}
+
+type Chapters []*Chapter
+
+// Save the chapter to the database
+func (c *Chapter) Save(ctx context.Context) error {
+	var err error
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+		_, err = db.NewInsert().Model(c).Exec(ctx)
+	} else {
+		_, err = db.NewUpdate().Model(c).
+			Where("id = ?", c.ID).
+			Exec(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete the chapter from the database
+func (c *Chapter) Delete(ctx context.Context) error {
+	_, err := db.NewDelete().
+		Model(c).
+		Where("id = ?", c.ID).
+		ForceDelete().
+		Exec(ctx)
+	return err
+}
+
+// FindChapterByID finds a chapter by ID within the given media
+func FindChapterByID(ctx context.Context, mediaID, id string) (*Chapter, error) {
+	chapter := &Chapter{}
+	err := db.NewSelect().
+		Model(chapter).
+		Where("id = ?", id).
+		Where("media_id = ?", mediaID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return chapter, nil
+}
+
+// Timestamp returns the start of the chapter in a human readable format
+func (c *Chapter) Timestamp() string {
+	return helpers.FormatTimestamp(c.Start)
+}
+
+// GetPublicURL returns the landing page URL that starts playback at the chapter
+func (c *Chapter) GetPublicURL() string {
+	return helpers.URL("/media/"+c.MediaID, fmt.Sprintf("t=%d", int(c.Start)))
+}
+
+// WebVTT renders the chapters as a WebVTT chapters track.
+// Each cue runs until the next chapter starts. The duration of the media
+// is not stored, so the final cue runs for an hour and players clamp it.
+func (c Chapters) WebVTT() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i, chapter := range c {
+		end := chapter.Start + 3600
+		if i+1 < len(c) && c[i+1].Start > chapter.Start {
+			end = c[i+1].Start
+		}
+		b.WriteString("\n")
+		b.WriteString(chapter.ID + "\n")
+		b.WriteString(vttTimestamp(chapter.Start) + " --> " + vttTimestamp(end) + "\n")
+		b.WriteString(vttEscape(chapter.Title) + "\n")
+	}
+	return b.String()
+}
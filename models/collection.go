@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/uptrace/bun"
+)
+
+type Collection struct {
+	baseModel
+	belongsToUser
+
+	ID           string          `bun:",pk,type:varchar(36)" json:"id"`
+	Title        string          `bun:",type:varchar(255)" json:"title"`
+	Description  string          `bun:",type:text" json:"description"`
+	CoverMediaID string          `bun:",type:varchar(36)" json:"-"`
+	Cover        *Media          `bun:"rel:belongs-to,join:cover_media_id=id" json:"-"`
+	Items        CollectionItems `bun:"rel:has-many,join:id=collection_id" json:"-"`
+}
+
+type Collections []*Collection
+
+// CollectionItem places a media item at a position in a collection
+type CollectionItem struct {
+	CollectionID string      `bun:",pk,type:varchar(36)"`
+	Collection   *Collection `bun:"rel:belongs-to,join:collection_id=id"`
+	MediaID      string      `bun:",pk,type:varchar(36)"`
+	Media        *Media      `bun:"rel:belongs-to,join:media_id=id"`
+	Position     int         `bun:",notnull"`
+}
+
+type CollectionItems []*CollectionItem
+
+// Save the collection to the database
+func (c *Collection) Save(ctx context.Context) error {
+	var err error
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+		c.setOwner(ctx)
+		_, err = db.NewInsert().Model(c).Exec(ctx)
+	} else {
+		_, err = db.NewUpdate().Model(c).
+			Where("id = ?", c.ID).
+			Exec(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete the collection from the database.
+// The media in the collection is left untouched.
+func (c *Collection) Delete(ctx context.Context) error {
+	_, err := db.NewDelete().
+		Model(c).
+		Where("id = ?", c.ID).
+		Exec(ctx)
+	return err
+}
+
+// FindCollectionByID finds a collection and its media in order
+func FindCollectionByID(ctx context.Context, id string) (*Collection, error) {
+	collection := &Collection{}
+	err := db.NewSelect().
+		Model(collection).
+		Relation("Cover").
+		Relation("Items", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("position ASC")
+		}).
+		Relation("Items.Media").
+		Relation("Items.Media.Chapters", orderChapters).
+		Where("collection.id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// FindAllCollections finds all collections
+func FindAllCollections(ctx context.Context) (Collections, error) {
+	collections := Collections{}
+	err := db.NewSelect().
+		Model(&collections).
+		Relation("Cover").
+		Relation("Items").
+		Order("collection.title ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// Library returns the media in the collection in order.
+// Media that has since been deleted is skipped.
+func (c *Collection) Library() Library {
+	library := Library{}
+	for _, item := range c.Items {
+		if item.Media != nil {
+			library = append(library, item.Media)
+		}
+	}
+	return library
+}
+
+// AddMedia appends a media item to the end of the collection
+func (c *Collection) AddMedia(ctx context.Context, mediaID string) error {
+	position := 0
+	for _, item := range c.Items {
+		if item.MediaID == mediaID {
+			return nil
+		}
+		if item.Position >= position {
+			position = item.Position + 1
+		}
+	}
+	item := &CollectionItem{
+		CollectionID: c.ID,
+		MediaID:      mediaID,
+		Position:     position,
+	}
+	_, err := db.NewInsert().Model(item).Exec(ctx)
+	return err
+}
+
+// RemoveMedia removes a media item from the collection
+func (c *Collection) RemoveMedia(ctx context.Context, mediaID string) error {
+	_, err := db.NewDelete().
+		Model((*CollectionItem)(nil)).
+		Where("collection_id = ?", c.ID).
+		Where("media_id = ?", mediaID).
+		Exec(ctx)
+	return err
+}
+
+// Reorder moves each of the given media IDs to its index in the list
+func (c *Collection) Reorder(ctx context.Context, mediaIDs []string) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for position, mediaID := range mediaIDs {
+			_, err := tx.NewUpdate().
+				Model((*CollectionItem)(nil)).
+				Set("position = ?", position).
+				Where("collection_id = ?", c.ID).
+				Where("media_id = ?", mediaID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPublicURL returns the URL of the public collection page
+func (c *Collection) GetPublicURL() string {
+	return helpers.URL("/collections/" + c.ID)
+}
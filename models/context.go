@@ -0,0 +1,45 @@
+package models
+
+import "context"
+
+type contextKey string
+
+const (
+	userContextKey     contextKey = "user"
+	sessionContextKey  contextKey = "session"
+	apiTokenContextKey contextKey = "api_token"
+)
+
+// WithUser returns a copy of the context carrying the logged in user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the logged in user carried by the context
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userContextKey).(*User)
+	return user, ok && user != nil
+}
+
+// WithSession returns a copy of the context carrying the current session
+func WithSession(ctx context.Context, session *UserSession) context.Context {
+	return context.WithValue(ctx, sessionContextKey, session)
+}
+
+// SessionFromContext returns the current session carried by the context
+func SessionFromContext(ctx context.Context) (*UserSession, bool) {
+	session, ok := ctx.Value(sessionContextKey).(*UserSession)
+	return session, ok && session != nil
+}
+
+// WithAPIToken returns a copy of the context carrying the API token used
+// for the request
+func WithAPIToken(ctx context.Context, token *APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenContextKey, token)
+}
+
+// APITokenFromContext returns the API token used for the request, if any
+func APITokenFromContext(ctx context.Context) (*APIToken, bool) {
+	token, ok := ctx.Value(apiTokenContextKey).(*APIToken)
+	return token, ok && token != nil
+}
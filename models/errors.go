@@ -0,0 +1,83 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Kind says what went wrong, so handlers can choose how to respond
+type Kind int
+
+const (
+	// KindInternal is an unexpected failure, such as a database error
+	KindInternal Kind = iota
+	// KindInvalid is input that failed validation
+	KindInvalid
+	// KindNotFound is a record that does not exist
+	KindNotFound
+	// KindConflict is a change that clashes with what is already stored
+	KindConflict
+	// KindUnauthorized is a missing or incorrect credential
+	KindUnauthorized
+	// KindForbidden is something the user is not allowed to do
+	KindForbidden
+)
+
+// UserError is an error whose message is meant for the user, such as a
+// failed validation. Other errors may reveal internals, so handlers log
+// them and show the request ID instead.
+type UserError struct {
+	kind    Kind
+	message string
+}
+
+// NewUserError returns an error that can be shown to the user about input
+// that failed validation
+func NewUserError(message string) error {
+	return &UserError{kind: KindInvalid, message: message}
+}
+
+// NewNotFoundError returns an error that can be shown to the user about a
+// record that does not exist
+func NewNotFoundError(message string) error {
+	return &UserError{kind: KindNotFound, message: message}
+}
+
+// NewConflictError returns an error that can be shown to the user about a
+// change that clashes with what is already stored
+func NewConflictError(message string) error {
+	return &UserError{kind: KindConflict, message: message}
+}
+
+// NewUnauthorizedError returns an error that can be shown to the user
+// about a missing or incorrect credential
+func NewUnauthorizedError(message string) error {
+	return &UserError{kind: KindUnauthorized, message: message}
+}
+
+// NewForbiddenError returns an error that can be shown to the user about
+// something they are not allowed to do
+func NewForbiddenError(message string) error {
+	return &UserError{kind: KindForbidden, message: message}
+}
+
+func (e *UserError) Error() string {
+	return e.message
+}
+
+// Kind says what went wrong
+func (e *UserError) Kind() Kind {
+	return e.kind
+}
+
+// ErrorKind says what went wrong in err. Finding no rows means the record
+// does not exist, and anything that is not a UserError is internal.
+func ErrorKind(err error) Kind {
+	var userErr *UserError
+	if errors.As(err, &userErr) {
+		return userErr.kind
+	} else if errors.Is(err, sql.ErrNoRows) {
+		return KindNotFound
+	}
+	return KindInternal
+}
@@ -3,10 +3,13 @@ package models
 import (
 	"context"
 	"database/sql"
-	"log"
-	"os"
+	"reflect"
 	"time"
 
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/config"
+	"github.com/nathanhollows/ace-video/metrics"
+	"github.com/nathanhollows/ace-video/tracing"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/mysqldialect"
 	"github.com/uptrace/bun/dialect/sqlitedialect"
@@ -16,29 +19,36 @@ import (
 
 var db *bun.DB
 
-func InitDB() {
-	var sqldb *sql.DB
-	var err error
+// settings is the configuration the models were started with
+var settings *config.Config
 
-	dataSourceName := os.Getenv("DB_CONNECTION")
-	if dataSourceName == "" {
-		log.Fatal("DB_CONNECTION not set")
-	}
+// InitDB connects to the database and creates or updates the tables
+func InitDB(c *config.Config) {
+	settings = c
 
-	driverName := os.Getenv("DB_TYPE")
-	switch driverName {
+	var sqldb *sql.DB
+	var err error
+	switch c.DB.Type {
 	case "mysql":
-		sqldb, err = sql.Open(driverName, dataSourceName)
+		sqldb, err = sql.Open(c.DB.Type, c.DB.Connection)
 		db = bun.NewDB(sqldb, mysqldialect.New())
 	case "sqlite3":
-		sqldb, err = sql.Open(sqliteshim.ShimName, dataSourceName)
+		sqldb, err = sql.Open(sqliteshim.ShimName, c.DB.Connection)
+		// SQLite allows one writer at a time and fails with "database is
+		// locked" rather than waiting, so requests, the trash purge and
+		// setup share a single connection. The busy timeout covers other
+		// processes, such as a backup.
+		sqldb.SetMaxOpenConns(1)
 		db = bun.NewDB(sqldb, sqlitedialect.New())
+		if err == nil {
+			_, err = db.Exec("PRAGMA busy_timeout = 5000")
+		}
 	default:
-		log.Fatalf("unsupported DB_TYPE: %s", driverName)
+		log.Fatal("Unsupported database type", "type", c.DB.Type)
 	}
 
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Error opening the database", "err", err)
 	}
 
 	db.AddQueryHook(bundebug.NewQueryHook(
@@ -49,29 +59,122 @@ func InitDB() {
 		// BUNDEBUG=2 logs all queries
 		bundebug.FromEnv("BUNDEBUG"),
 	))
+	db.AddQueryHook(metrics.QueryHook{})
+	db.AddQueryHook(tracing.QueryHook{})
+
+	// Register the join table for many to many relations
+	db.RegisterModel((*TeamMember)(nil))
 
 	var models = []interface{}{
 		(*User)(nil),
 		(*Media)(nil),
 		(*Tag)(nil),
+		(*Chapter)(nil),
+		(*Transcript)(nil),
+		(*Collection)(nil),
+		(*CollectionItem)(nil),
+		(*MediaVersion)(nil),
+		(*AuditEntry)(nil),
+		(*UserToken)(nil),
+		(*UserSession)(nil),
+		(*RecoveryCode)(nil),
+		(*APIToken)(nil),
+		(*Team)(nil),
+		(*TeamMember)(nil),
 	}
 
 	for _, model := range models {
 		_, err := db.NewCreateTable().Model(model).IfNotExists().Exec(context.Background())
 		if err != nil {
-			log.Fatal(err)
+			log.Fatal("Error creating tables", "err", err)
+		}
+		added, err := addMissingColumns(context.Background(), model)
+		if err != nil {
+			log.Fatal("Error updating tables", "err", err)
+		}
+		// New accounts are viewers unless given a role, but accounts
+		// created before roles existed are owners
+		if _, ok := model.(*User); ok && added["role"] {
+			_, err = db.NewUpdate().
+				Model((*User)(nil)).
+				Set("role = ?", RoleOwner).
+				Where("role = ?", RoleViewer).
+				WhereAllWithDeleted().
+				Exec(context.Background())
+			if err != nil {
+				log.Fatal("Error updating tables", "err", err)
+			}
 		}
 	}
 
 }
 
+// addMissingColumns adds columns for any fields added to a model since its
+// table was created, and returns the names of the columns it added.
+// Columns are added without NOT NULL so existing rows remain valid.
+func addMissingColumns(ctx context.Context, model interface{}) (map[string]bool, error) {
+	table := db.Table(reflect.TypeOf(model).Elem())
+
+	rows, err := db.QueryContext(ctx, "SELECT * FROM ? LIMIT 0", table.SQLName)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	// The rows hold the only SQLite connection until they are closed
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	added := map[string]bool{}
+	for _, column := range columns {
+		existing[column] = true
+	}
+
+	for _, field := range table.Fields {
+		if existing[field.Name] {
+			continue
+		}
+		definition := field.CreateTableSQLType
+		if field.SQLDefault != "" {
+			definition += " DEFAULT " + field.SQLDefault
+		}
+		_, err := db.NewAddColumn().
+			Model(model).
+			ColumnExpr("? ?", field.SQLName, bun.Safe(definition)).
+			Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+		added[field.Name] = true
+	}
+	return added, nil
+}
+
 type baseModel struct {
 	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"created_at"`
 	UpdatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"updated_at"`
 	DeletedAt time.Time `bun:",soft_delete,nullzero" json:"-"`
 }
 
+// setOwner records the user in the context as the owner of a new record
+func (b *belongsToUser) setOwner(ctx context.Context) {
+	if user, ok := UserFromContext(ctx); ok && b.UserID == "" {
+		b.UserID = user.ID
+	}
+}
+
 type belongsToUser struct {
 	UserID string `bun:",notnull,type:varchar(36)" json:"-"`
-	User   *User  `bun:"rel:belongs-to,join:id=id" json:"-"`
+	User   *User  `bun:"rel:belongs-to,join:user_id=id" json:"-"`
+}
+
+// Ping checks that the database can be reached
+func Ping(ctx context.Context) error {
+	return db.PingContext(ctx)
+}
+
+// CloseDB closes the database connection once the server has stopped
+func CloseDB() error {
+	return db.Close()
 }
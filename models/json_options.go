@@ -9,4 +9,6 @@ type JSONOptions struct {
 	Tags   string
 	Type   string
 	ID     string
+	// Collection limits the media to a single collection
+	Collection string
 }
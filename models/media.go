@@ -2,54 +2,103 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/nathanhollows/ace-video/tracing"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Media struct {
 	baseModel
 	belongsToUser
 
-	ID          string `bun:",pk,type:varchar(36)" json:"id"`
-	Title       string `bun:",type:varchar(255)" json:"title"`
-	FileName    string `bun:",type:varchar(255)" json:"-"`
-	MimeType    string `bun:",type:varchar(255)" json:"mime_type"`
-	FilePath    string `bun:",type:varchar(255)" json:"file_path"`
-	Description string `bun:",type:text" json:"description"`
-	Caption     string `bun:",type:text" json:"caption"`
-	Tags        Tags   `bun:"rel:has-many,join:id=media_id" json:"tags"`
+	ID          string      `bun:",pk,type:varchar(36)" json:"id"`
+	TeamID      string      `bun:",nullzero,type:varchar(36)" json:"-"`
+	Team        *Team       `bun:"rel:belongs-to,join:team_id=id" json:"-"`
+	Title       string      `bun:",type:varchar(255)" json:"title"`
+	FileName    string      `bun:",type:varchar(255)" json:"-"`
+	MimeType    string      `bun:",type:varchar(255)" json:"mime_type"`
+	FilePath    string      `bun:",type:varchar(255)" json:"file_path"`
+	Description string      `bun:",type:text" json:"description"`
+	Caption     string      `bun:",type:text" json:"caption"`
+	Tags        Tags        `bun:"rel:has-many,join:id=media_id" json:"tags"`
+	Chapters    Chapters    `bun:"rel:has-many,join:id=media_id" json:"chapters"`
+	Transcript  *Transcript `bun:"rel:has-one,join:id=media_id" json:"-"`
+
+	// Image metadata read from the uploaded file
+	Width        int        `json:"width,omitempty"`
+	Height       int        `json:"height,omitempty"`
+	Orientation  int        `json:"-"`
+	CapturedAt   *time.Time `json:"captured_at,omitempty"`
+	KeepMetadata bool       `json:"-"`
 }
 
 type Library []*Media
 
 // Save the media to the database
 func (m *Media) Save(ctx context.Context) error {
-	var err error
 	if m.ID == "" {
 		m.ID = uuid.New().String()
-		_, err = db.NewInsert().Model(m).Exec(ctx)
-	} else {
-		_, err = db.NewUpdate().Model(m).
-			Where("id = ?", m.ID).
-			Exec(ctx)
+		return m.insert(ctx)
 	}
 
-	if err != nil {
+	before := &Media{}
+	err := db.NewSelect().
+		Model(before).
+		Where("id = ?", m.ID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		// New media may be given an ID up front so it matches the file name
+		return m.insert(ctx)
+	} else if err != nil {
 		return err
 	}
-	return nil
+
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().Model(m).
+			Where("id = ?", m.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditUpdate, before, m)
+	})
+}
+
+// insert adds new media to the database
+func (m *Media) insert(ctx context.Context) error {
+	m.setOwner(ctx)
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().Model(m).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditCreate, nil, m)
+	})
 }
 
-// FindMediaByID finds media by ID
+// FindMediaByID finds media by ID if the user in the context can see it
 func FindMediaByID(ctx context.Context, id string) (*Media, error) {
 	media := &Media{}
 	err := db.NewSelect().
 		Model(media).
 		Relation("Tags").
-		Where("id = ?", id).
+		Relation("Chapters", orderChapters).
+		Relation("Transcript").
+		Relation("Team").
+		Where("media.id = ?", id).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			return scopeToTeams(ctx, q)
+		}).
 		Scan(ctx)
 	if err != nil {
 		return nil, err
@@ -57,12 +106,16 @@ func FindMediaByID(ctx context.Context, id string) (*Media, error) {
 	return media, nil
 }
 
-// FindAllMedia finds all media
+// FindAllMedia finds all media visible to the user in the context
 func FindAllMedia(ctx context.Context) (Library, error) {
 	media := Library{}
 	err := db.NewSelect().
 		Model(&media).
 		Relation("Tags").
+		Relation("Chapters", orderChapters).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			return scopeToTeams(ctx, q)
+		}).
 		Scan(ctx)
 	if err != nil {
 		return nil, err
@@ -75,7 +128,9 @@ func FindMatchingMedia(ctx context.Context, options JSONOptions) (Library, error
 	media := Library{}
 	query := db.NewSelect().
 		Model(&media).
-		Relation("Tags")
+		Relation("Tags").
+		Relation("Chapters", orderChapters)
+	query = scopeToTeams(ctx, query)
 	if options.Limit != 0 {
 		query = query.Limit(options.Limit)
 	}
@@ -86,7 +141,16 @@ func FindMatchingMedia(ctx context.Context, options JSONOptions) (Library, error
 		query = query.OrderExpr(options.Sort + " " + options.Order)
 	}
 	if options.Search != "" {
-		query = query.Where("title LIKE ?", "%"+options.Search+"%")
+		// Match the title or anything said in the transcript
+		search := "%" + options.Search + "%"
+		transcripts := db.NewSelect().
+			Model((*Transcript)(nil)).
+			Column("media_id").
+			Where("text LIKE ?", search)
+		query = query.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("media.title LIKE ?", search).
+				WhereOr("media.id IN (?)", transcripts)
+		})
 	}
 	if options.Type != "" {
 		query = query.Where("mime_type LIKE ?", options.Type+"%")
@@ -99,6 +163,11 @@ func FindMatchingMedia(ctx context.Context, options JSONOptions) (Library, error
 	if options.ID != "" {
 		query = query.Where("id = ?", options.ID)
 	}
+	if options.Collection != "" {
+		query = query.
+			Join("JOIN collection_items AS collection_item ON collection_item.media_id = media.id").
+			Where("collection_item.collection_id = ?", options.Collection)
+	}
 	err := query.Scan(ctx)
 	if err != nil {
 		return nil, err
@@ -125,6 +194,63 @@ func SaveMedia(ctx context.Context, file []byte, fileName string) error {
 	return nil
 }
 
+// StoragePath returns the location of the public file on disk
+func (m *Media) StoragePath() string {
+	return filepath.Join("assets", m.FilePath)
+}
+
+// OriginalPath returns the location of the file exactly as it was uploaded.
+// Originals are kept outside of assets so they are never served.
+func (m *Media) OriginalPath() string {
+	return filepath.Join("static", "originals", filepath.Base(m.FilePath))
+}
+
+// ProcessImage reads the metadata of an uploaded image and publishes it.
+// The upload is kept as the original, and unless KeepMetadata is set the
+// public copy has its location and device metadata stripped.
+func (m *Media) ProcessImage(ctx context.Context) (err error) {
+	_, span := tracing.Span(ctx, "storage.process_image", attribute.String("media.id", m.ID))
+	defer func() { tracing.End(span, err) }()
+
+	original := m.OriginalPath()
+	if _, err := os.Stat(original); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(original), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(m.StoragePath(), original)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(original)
+	if err != nil {
+		return err
+	}
+
+	// The size is left unset for formats we cannot decode
+	meta, err := helpers.ReadImageMetadata(data)
+	if err == nil {
+		m.Width = meta.Width
+		m.Height = meta.Height
+		m.Orientation = meta.Orientation
+		m.CapturedAt = nil
+		if !meta.CapturedAt.IsZero() {
+			m.CapturedAt = &meta.CapturedAt
+		}
+	}
+
+	public := data
+	if !m.KeepMetadata {
+		public, err = helpers.StripImageMetadata(data)
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(m.StoragePath(), public, 0644)
+}
+
 // Type returns the type of the model
 func (m *Media) Type() string {
 	// Can be "image", "video", "audio", "document", "other"
@@ -135,8 +261,17 @@ func (m *Media) Type() string {
 
 // Get Public URL returns the URL of the file
 func (m *Media) GetPublicURL() string {
-	site := os.Getenv("SITE_URL")
-	return site + "/assets" + m.FilePath
+	return settings.SiteURL + "/assets" + m.FilePath
+}
+
+// GetLandingURL returns the URL of the public landing page for the media
+func (m *Media) GetLandingURL() string {
+	return helpers.URL("/media/" + m.ID)
+}
+
+// orderChapters sorts chapters by their start time
+func orderChapters(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.Order("start ASC")
 }
 
 // MarshalJSON marshals the library to JSON without modifying the original library
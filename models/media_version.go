@@ -0,0 +1,207 @@
+package models
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/tracing"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// MediaVersion is a file that a media item used to serve.
+// Versions are kept so a replaced file can be rolled back.
+type MediaVersion struct {
+	baseModel
+
+	ID       string `bun:",pk,type:varchar(36)" json:"id"`
+	MediaID  string `bun:",notnull,type:varchar(36)" json:"-"`
+	FileName string `bun:",type:varchar(255)" json:"file_name"`
+	MimeType string `bun:",type:varchar(255)" json:"mime_type"`
+	FilePath string `bun:",type:varchar(255)" json:"-"`
+}
+
+type MediaVersions []*MediaVersion
+
+// ArchivePath returns the location of the archived file on disk.
+// Archived files are kept outside of assets so they are never served.
+func (v *MediaVersion) ArchivePath() string {
+	return filepath.Join("static", "versions", filepath.Base(v.FilePath))
+}
+
+// media describes the version as media so it can share its file handling
+func (v *MediaVersion) media() *Media {
+	return &Media{MimeType: v.MimeType, FilePath: v.FilePath}
+}
+
+// FindMediaVersions finds the previous versions of a media item, newest first
+func FindMediaVersions(ctx context.Context, mediaID string) (MediaVersions, error) {
+	versions := MediaVersions{}
+	err := db.NewSelect().
+		Model(&versions).
+		Where("media_id = ?", mediaID).
+		Order("created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// FindMediaVersionByID finds a previous version of a media item
+func FindMediaVersionByID(ctx context.Context, mediaID, id string) (*MediaVersion, error) {
+	version := &MediaVersion{}
+	err := db.NewSelect().
+		Model(version).
+		Where("id = ?", id).
+		Where("media_id = ?", mediaID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// ReplaceFile swaps the file served by the media for one already saved to
+// assets. The ID, tags, chapters and other metadata are kept, and the
+// previous file is kept as a version.
+func (m *Media) ReplaceFile(ctx context.Context, fileName, mimeType, filePath string) error {
+	incoming := &MediaVersion{
+		FileName: fileName,
+		MimeType: mimeType,
+		FilePath: filePath,
+	}
+	return m.swapFile(ctx, incoming, func() (func(), error) {
+		// The upload and its processed copies are removed if the swap fails
+		return func() {
+			os.Remove(incoming.media().StoragePath())
+			os.Remove(incoming.media().OriginalPath())
+		}, nil
+	})
+}
+
+// Rollback restores a previous version of the file.
+// The file being replaced is kept as a version in its place.
+func (m *Media) Rollback(ctx context.Context, version *MediaVersion) error {
+	return m.swapFile(ctx, version, func() (func(), error) {
+		// Put the archived file back where an upload would be
+		restore := version.media().StoragePath()
+		if version.media().Type() == "image" {
+			restore = version.media().OriginalPath()
+		}
+		err := os.MkdirAll(filepath.Dir(restore), 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Rename(version.ArchivePath(), restore)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			os.Rename(restore, version.ArchivePath())
+			if restore != version.media().StoragePath() {
+				os.Remove(version.media().StoragePath())
+			}
+		}, nil
+	})
+}
+
+// swapFile switches the media to the given file and archives the current
+// one. restore puts the incoming file in place on disk and returns a
+// function that undoes it. The current file is only archived once the
+// database has been updated, so a failure leaves the media serving it.
+func (m *Media) swapFile(ctx context.Context, incoming *MediaVersion, restore func() (func(), error)) (err error) {
+	ctx, span := tracing.Span(ctx, "storage.swap_file", attribute.String("media.id", m.ID))
+	defer func() { tracing.End(span, err) }()
+
+	before := *m
+	archived := &MediaVersion{
+		ID:       uuid.New().String(),
+		MediaID:  m.ID,
+		FileName: m.FileName,
+		MimeType: m.MimeType,
+		FilePath: m.FilePath,
+	}
+
+	undo, err := restore()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			*m = before
+			undo()
+		}
+	}()
+
+	m.FileName = incoming.FileName
+	m.MimeType = incoming.MimeType
+	m.FilePath = incoming.FilePath
+	m.Width, m.Height, m.Orientation, m.CapturedAt = 0, 0, 0, nil
+	if m.Type() == "image" {
+		err = m.ProcessImage(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	m.UpdatedAt = time.Now()
+
+	// Archive the original upload where there is one, as the public copy of
+	// an image may have had its metadata stripped
+	current := before.StoragePath()
+	if _, err := os.Stat(before.OriginalPath()); err == nil {
+		current = before.OriginalPath()
+	}
+	err = os.MkdirAll(filepath.Dir(archived.ArchivePath()), 0755)
+	if err != nil {
+		return err
+	}
+
+	moved := false
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().Model(m).
+			Where("id = ?", m.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		err = recordAudit(ctx, tx, AuditUpdate, &before, m)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewInsert().Model(archived).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if incoming.ID != "" {
+			_, err = tx.NewDelete().
+				Model(incoming).
+				Where("id = ?", incoming.ID).
+				ForceDelete().
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		// Moved last, so the transaction is rolled back if it fails
+		err = os.Rename(current, archived.ArchivePath())
+		if err != nil {
+			return err
+		}
+		moved = true
+		return nil
+	})
+	if err != nil {
+		if moved {
+			os.Rename(archived.ArchivePath(), current)
+		}
+		return err
+	}
+	if current != before.StoragePath() {
+		os.Remove(before.StoragePath())
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/ratelimit"
+	"github.com/nathanhollows/ace-video/sessions"
+	"github.com/uptrace/bun"
+)
+
+// UserSession is a signed in browser. The session cookie only carries a
+// random token, so deleting the row signs the browser out.
+type UserSession struct {
+	ID         string    `bun:",pk,type:varchar(36)"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UserID     string    `bun:",notnull,type:varchar(36)"`
+	User       *User     `bun:"rel:belongs-to,join:user_id=id"`
+	Hash       string    `bun:",unique,type:varchar(64)"`
+	UserAgent  string    `bun:",type:varchar(255)"`
+	IPAddress  string    `bun:",type:varchar(64)"`
+	LastSeenAt time.Time `bun:",notnull"`
+	ExpiresAt  time.Time `bun:",notnull"`
+}
+
+type UserSessions []*UserSession
+
+// lastSeenInterval limits how often LastSeenAt is written
+const lastSeenInterval = time.Minute
+
+var ErrSessionExpired = NewUnauthorizedError("your session has expired")
+
+// SessionLifetime is how long a sign in lasts
+func SessionLifetime() time.Duration {
+	return settings.SessionLifetime()
+}
+
+// NewUserSession signs the user in on the requesting browser. It returns
+// the token to store in the session cookie.
+func NewUserSession(ctx context.Context, userID string, r *http.Request) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(secret)
+
+	ip := ratelimit.ClientIP(r)
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	now := time.Now()
+	session := &UserSession{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Hash:       hashToken(plain),
+		UserAgent:  userAgent,
+		IPAddress:  ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionLifetime()),
+	}
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Clear out expired sessions while we're here
+		_, err := tx.NewDelete().
+			Model((*UserSession)(nil)).
+			Where("expires_at < ?", now).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewInsert().Model(session).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// FindSessionByRequest finds the unexpired session and user for the
+// request's session cookie, and records that the session was seen
+func FindSessionByRequest(r *http.Request) (*UserSession, error) {
+	cookie, err := sessions.Get(r, "admin")
+	if err != nil {
+		return nil, err
+	}
+	plain, ok := cookie.Values["session_id"].(string)
+	if !ok {
+		return nil, errors.New("User not found")
+	}
+
+	ctx := r.Context()
+	session := &UserSession{}
+	err = db.NewSelect().
+		Model(session).
+		Relation("User").
+		Where("user_session.hash = ?", hashToken(plain)).
+		Scan(ctx)
+	if err != nil || session.User == nil {
+		return nil, ErrSessionExpired
+	}
+	if time.Now().After(session.ExpiresAt) {
+		session.Revoke(ctx)
+		return nil, ErrSessionExpired
+	}
+
+	if time.Since(session.LastSeenAt) > lastSeenInterval {
+		session.LastSeenAt = time.Now()
+		_, err = db.NewUpdate().
+			Model(session).
+			Column("last_seen_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error("Error updating session", "err", err)
+		}
+	}
+	return session, nil
+}
+
+// FindUserSessions finds the user's unexpired sessions, most recently used
+// first
+func FindUserSessions(ctx context.Context, userID string) (UserSessions, error) {
+	sessions := UserSessions{}
+	err := db.NewSelect().
+		Model(&sessions).
+		Where("user_id = ?", userID).
+		Where("expires_at > ?", time.Now()).
+		Order("last_seen_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// FindUserSessionByID finds one of the user's sessions
+func FindUserSessionByID(ctx context.Context, userID, id string) (*UserSession, error) {
+	session := &UserSession{}
+	err := db.NewSelect().
+		Model(session).
+		Where("id = ?", id).
+		Where("user_id = ?", userID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Revoke signs the session out
+func (s *UserSession) Revoke(ctx context.Context) error {
+	_, err := db.NewDelete().
+		Model((*UserSession)(nil)).
+		Where("id = ?", s.ID).
+		Exec(ctx)
+	return err
+}
+
+// RevokeOtherSessions signs the user out everywhere except the given session
+func RevokeOtherSessions(ctx context.Context, userID, except string) error {
+	return revokeUserSessions(ctx, db, userID, except)
+}
+
+// revokeUserSessions signs the user out everywhere except the session with
+// the given ID, which may be empty
+func revokeUserSessions(ctx context.Context, idb bun.IDB, userID, except string) error {
+	query := idb.NewDelete().
+		Model((*UserSession)(nil)).
+		Where("user_id = ?", userID)
+	if except != "" {
+		query = query.Where("id != ?", except)
+	}
+	_, err := query.Exec(ctx)
+	return err
+}
+
+// CountActiveSessions counts the unexpired sessions across all users
+func CountActiveSessions(ctx context.Context) (int, error) {
+	return db.NewSelect().
+		Model((*UserSession)(nil)).
+		Where("expires_at > ?", time.Now()).
+		Count(ctx)
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// TokenSetup authorises creating the first user. It belongs to no user.
+const TokenSetup TokenPurpose = "setup"
+
+// setupTokenLifetime is how long a setup link works
+const setupTokenLifetime = 24 * time.Hour
+
+// ErrAlreadySetUp is returned once the first user exists
+var ErrAlreadySetUp = NewConflictError("the system has already been set up")
+
+// NewSetupToken issues a token for creating the first user, replacing any
+// earlier one. It fails once a user exists.
+func NewSetupToken(ctx context.Context) (string, error) {
+	exists, err := CheckAnyUsers()
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrAlreadySetUp
+	}
+	return NewUserToken(ctx, "", TokenSetup, setupTokenLifetime)
+}
+
+// CheckSetupToken reports whether the setup token is valid
+func CheckSetupToken(ctx context.Context, plain string) bool {
+	count, err := db.NewSelect().
+		Model((*UserToken)(nil)).
+		Where("hash = ?", hashToken(plain)).
+		Where("purpose = ?", TokenSetup).
+		Where("used_at IS NULL").
+		Where("expires_at > ?", time.Now()).
+		Count(ctx)
+	return err == nil && count == 1
+}
+
+// CreateOwner uses the setup token to create the first user as an owner.
+// The token is spent in the same transaction, so concurrent submissions
+// cannot both create an owner.
+func CreateOwner(ctx context.Context, setupToken, email, password string) (*User, error) {
+	email, err := ValidateEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePassword(password, email); err != nil {
+		return nil, err
+	}
+
+	user := NewUser(email, password)
+	user.Role = RoleOwner
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*UserToken)(nil)).
+			Set("used_at = ?", time.Now()).
+			Where("hash = ?", hashToken(setupToken)).
+			Where("purpose = ?", TokenSetup).
+			Where("used_at IS NULL").
+			Where("expires_at > ?", time.Now()).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			return ErrInvalidToken
+		}
+
+		count, err := tx.NewSelect().Model((*User)(nil)).Count(ctx)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadySetUp
+		}
+		_, err = tx.NewInsert().Model(user).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
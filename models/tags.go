@@ -2,6 +2,9 @@ package models
 
 import (
 	"context"
+	"strings"
+
+	"github.com/uptrace/bun"
 )
 
 type Tag struct {
@@ -18,11 +21,30 @@ type Tags []*Tag
 
 // Save the tag to the database
 func (t *Tag) Save(ctx context.Context) error {
-	_, err := db.NewInsert().Model(t).Exec(ctx)
-	if err != nil {
-		return err
-	}
-	return nil
+	t.setOwner(ctx)
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewInsert().Model(t).Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditCreate, nil, t)
+	})
+}
+
+// Delete the tag from the database
+func (t *Tag) Delete(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model(t).
+			Where("id = ?", t.ID).
+			Where("media_id = ?", t.MediaID).
+			ForceDelete().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditDelete, t, nil)
+	})
 }
 
 // FindAllTags finds all Tag
@@ -36,3 +58,13 @@ func FindAllTags(ctx context.Context) (Tags, error) {
 	}
 	return tags, nil
 }
+
+// HasTag reports whether the media has a tag with the given name
+func (m *Media) HasTag(name string) bool {
+	for _, tag := range m.Tags {
+		if strings.EqualFold(tag.Name, name) {
+			return true
+		}
+	}
+	return false
+}
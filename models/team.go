@@ -0,0 +1,177 @@
+package models
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Team is a group of users, such as a department, that share a library
+type Team struct {
+	baseModel
+
+	ID      string `bun:",pk,type:varchar(36)" json:"id"`
+	Name    string `bun:",unique,type:varchar(255)" json:"name"`
+	Members Users  `bun:"m2m:team_members,join:Team=User" json:"-"`
+}
+
+type Teams []*Team
+
+// TeamMember links a user to a team
+type TeamMember struct {
+	TeamID string `bun:",pk,type:varchar(36)"`
+	Team   *Team  `bun:"rel:belongs-to,join:team_id=id"`
+	UserID string `bun:",pk,type:varchar(36)"`
+	User   *User  `bun:"rel:belongs-to,join:user_id=id"`
+}
+
+// Save the team to the database
+func (t *Team) Save(ctx context.Context) error {
+	if t.Name == "" {
+		return NewUserError("teams need a name")
+	}
+	var err error
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+		_, err = db.NewInsert().Model(t).Exec(ctx)
+	} else {
+		_, err = db.NewUpdate().Model(t).
+			Where("id = ?", t.ID).
+			Exec(ctx)
+	}
+	return err
+}
+
+// Delete the team. Its media stays in place and is shared with everyone.
+func (t *Team) Delete(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().
+			Model((*Media)(nil)).
+			Set("team_id = NULL").
+			Where("team_id = ?", t.ID).
+			WhereAllWithDeleted().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().
+			Model((*TeamMember)(nil)).
+			Where("team_id = ?", t.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().
+			Model(t).
+			Where("id = ?", t.ID).
+			ForceDelete().
+			Exec(ctx)
+		return err
+	})
+}
+
+// Contains reports whether the team with the given ID is in the list
+func (t Teams) Contains(id string) bool {
+	for _, team := range t {
+		if team.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AddMember adds a user to the team
+func (t *Team) AddMember(ctx context.Context, userID string) error {
+	_, err := db.NewInsert().
+		Model(&TeamMember{TeamID: t.ID, UserID: userID}).
+		Ignore().
+		Exec(ctx)
+	return err
+}
+
+// RemoveMember takes a user out of the team
+func (t *Team) RemoveMember(ctx context.Context, userID string) error {
+	_, err := db.NewDelete().
+		Model((*TeamMember)(nil)).
+		Where("team_id = ?", t.ID).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	return err
+}
+
+// FindTeamByID finds a team and its members
+func FindTeamByID(ctx context.Context, id string) (*Team, error) {
+	team := &Team{}
+	err := db.NewSelect().
+		Model(team).
+		Relation("Members").
+		Where("team.id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+// FindAllTeams finds every team and its members
+func FindAllTeams(ctx context.Context) (Teams, error) {
+	teams := Teams{}
+	err := db.NewSelect().
+		Model(&teams).
+		Relation("Members", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("email ASC")
+		}).
+		Order("team.name ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// FindUserTeams finds the teams the user belongs to
+func FindUserTeams(ctx context.Context, userID string) (Teams, error) {
+	teams := Teams{}
+	err := db.NewSelect().
+		Model(&teams).
+		Where("team.id IN (?)", userTeamIDs(userID)).
+		Order("team.name ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// IsMember reports whether the user belongs to the team
+func (t *Team) IsMember(ctx context.Context, userID string) (bool, error) {
+	return db.NewSelect().
+		Model((*TeamMember)(nil)).
+		Where("team_id = ?", t.ID).
+		Where("user_id = ?", userID).
+		Exists(ctx)
+}
+
+// userTeamIDs selects the IDs of the teams the user belongs to
+func userTeamIDs(userID string) *bun.SelectQuery {
+	return db.NewSelect().
+		Model((*TeamMember)(nil)).
+		Column("team_id").
+		Where("user_id = ?", userID)
+}
+
+// scopeToTeams limits a media query to what the user in the context can
+// see: their own uploads, their teams' libraries, and media not assigned to
+// a team. Queries without a user, such as the public JSON, are unchanged.
+func scopeToTeams(ctx context.Context, q *bun.SelectQuery) *bun.SelectQuery {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return q
+	}
+	return q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("media.team_id IS NULL").
+			WhereOr("media.team_id IN (?)", userTeamIDs(user.ID)).
+			WhereOr("media.user_id = ?", user.ID)
+	})
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type TokenPurpose string
+
+const (
+	TokenInvite TokenPurpose = "invite"
+	TokenReset  TokenPurpose = "reset"
+)
+
+// UserToken is a single use, time limited secret sent to a user.
+// Only a hash of the token is stored.
+type UserToken struct {
+	ID        string       `bun:",pk,type:varchar(36)"`
+	CreatedAt time.Time    `bun:",nullzero,notnull,default:current_timestamp"`
+	UserID    string       `bun:",notnull,type:varchar(36)"`
+	User      *User        `bun:"rel:belongs-to,join:user_id=id"`
+	Purpose   TokenPurpose `bun:",type:varchar(16)"`
+	Hash      string       `bun:",unique,type:varchar(64)"`
+	ExpiresAt time.Time    `bun:",notnull"`
+	UsedAt    time.Time    `bun:",nullzero"`
+}
+
+var ErrInvalidToken = NewUserError("this link is invalid or has expired")
+
+// NewUserToken issues a token for the user, replacing any unused token
+// issued for the same purpose. It returns the token to send to the user.
+func NewUserToken(ctx context.Context, userID string, purpose TokenPurpose, ttl time.Duration) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(secret)
+
+	token := &UserToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hashToken(plain),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model((*UserToken)(nil)).
+			Where("user_id = ?", userID).
+			Where("purpose = ?", purpose).
+			Where("used_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewInsert().Model(token).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// FindUserToken finds an unused, unexpired token and its user
+func FindUserToken(ctx context.Context, plain string, purpose TokenPurpose) (*UserToken, error) {
+	token := &UserToken{}
+	err := db.NewSelect().
+		Model(token).
+		Relation("User").
+		Where("hash = ?", hashToken(plain)).
+		Where("purpose = ?", purpose).
+		Where("used_at IS NULL").
+		Scan(ctx)
+	if err != nil || token.User == nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// SetPassword uses the token to set the user's password. Both happen in one
+// transaction, so a token is only spent if the password is changed.
+func (t *UserToken) SetPassword(ctx context.Context, password string) error {
+	t.User.SetPassword(password)
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*UserToken)(nil)).
+			Set("used_at = ?", time.Now()).
+			Where("id = ?", t.ID).
+			Where("used_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		// Another request may have used the token first
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrInvalidToken
+		}
+		return t.User.savePassword(ctx, tx, "")
+	})
+}
+
+// hashToken hashes a token for storage. Tokens are random, so a plain
+// SHA-256 is enough.
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type TranscriptFormat string
+
+const (
+	TranscriptText TranscriptFormat = "text"
+	TranscriptVTT  TranscriptFormat = "vtt"
+)
+
+type Transcript struct {
+	baseModel
+
+	ID      string           `bun:",pk,type:varchar(36)" json:"id"`
+	MediaID string           `bun:",notnull,unique,type:varchar(36)" json:"-"`
+	Format  TranscriptFormat `bun:",type:varchar(16)" json:"format"`
+	// Content is the transcript as it was provided
+	Content string `bun:",type:text" json:"-"`
+	// Text is the plain text of the transcript, used for search
+	Text string `bun:",type:text" json:"text"`
+}
+
+// NewTranscript creates a transcript from plain text
+func NewTranscript(mediaID, text string) *Transcript {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return &Transcript{
+		MediaID: mediaID,
+		Format:  TranscriptText,
+		Content: text,
+		Text:    text,
+	}
+}
+
+// NewTranscriptFromVTT creates a transcript from a WebVTT document
+func NewTranscriptFromVTT(mediaID, content string) (*Transcript, error) {
+	cues, err := ParseWebVTT(content)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{
+		MediaID: mediaID,
+		Format:  TranscriptVTT,
+		Content: content,
+		Text:    cues.Text(),
+	}, nil
+}
+
+// Save the transcript to the database, replacing any existing transcript
+func (t *Transcript) Save(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model((*Transcript)(nil)).
+			Where("media_id = ?", t.MediaID).
+			ForceDelete().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		t.ID = uuid.New().String()
+		_, err = tx.NewInsert().Model(t).Exec(ctx)
+		return err
+	})
+}
+
+// Delete the transcript from the database
+func (t *Transcript) Delete(ctx context.Context) error {
+	_, err := db.NewDelete().
+		Model(t).
+		Where("id = ?", t.ID).
+		ForceDelete().
+		Exec(ctx)
+	return err
+}
+
+// Cues returns the timed cues of the transcript.
+// Plain text transcripts have no timings and return no cues.
+func (t *Transcript) Cues() Cues {
+	if t.Format != TranscriptVTT {
+		return nil
+	}
+	cues, _ := ParseWebVTT(t.Content)
+	return cues
+}
+
+// Paragraphs splits a plain text transcript on blank lines
+func (t *Transcript) Paragraphs() []string {
+	paragraphs := []string{}
+	for _, p := range strings.Split(t.Text, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
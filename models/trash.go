@@ -0,0 +1,202 @@
+package models
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/nathanhollows/ace-video/tracing"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Delete moves the media to the trash
+func (m *Media) Delete(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model(m).
+			Where("id = ?", m.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditDelete, m, nil)
+	})
+}
+
+// FindDeletedMedia finds all media in the trash that the user in the
+// context can see, most recently deleted first
+func FindDeletedMedia(ctx context.Context) (Library, error) {
+	media := Library{}
+	err := db.NewSelect().
+		Model(&media).
+		WhereDeleted().
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			return scopeToTeams(ctx, q)
+		}).
+		Order("deleted_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// FindDeletedMediaByID finds media in the trash by ID if the user in the
+// context can see it
+func FindDeletedMediaByID(ctx context.Context, id string) (*Media, error) {
+	media := &Media{}
+	err := db.NewSelect().
+		Model(media).
+		WhereDeleted().
+		Where("media.id = ?", id).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			return scopeToTeams(ctx, q)
+		}).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// Restore takes the media out of the trash
+func (m *Media) Restore(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().
+			Model((*Media)(nil)).
+			WhereDeleted().
+			Set("deleted_at = NULL").
+			Where("id = ?", m.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, AuditRestore, nil, m)
+	})
+}
+
+// Purge permanently deletes the media, everything attached to it and its files
+func (m *Media) Purge(ctx context.Context) error {
+	versions, err := FindMediaVersions(ctx, m.ID)
+	if err != nil {
+		return err
+	}
+
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		attached := []interface{}{
+			(*Tag)(nil),
+			(*Chapter)(nil),
+			(*Transcript)(nil),
+			(*CollectionItem)(nil),
+			(*MediaVersion)(nil),
+		}
+		for _, model := range attached {
+			_, err := tx.NewDelete().
+				Model(model).
+				Where("media_id = ?", m.ID).
+				ForceDelete().
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.NewDelete().
+			Model((*Media)(nil)).
+			Where("id = ?", m.ID).
+			ForceDelete().
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The record is gone, so a missing file is not worth failing over
+	paths := []string{m.StoragePath(), m.OriginalPath()}
+	for _, version := range versions {
+		paths = append(paths, version.ArchivePath())
+	}
+	_, span := tracing.Span(ctx, "storage.remove_files",
+		attribute.String("media.id", m.ID),
+		attribute.Int("files", len(paths)),
+	)
+	defer span.End()
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.FromContext(ctx).Error("Error removing media file", "err", err)
+		}
+	}
+	return nil
+}
+
+// PurgeDeletedMedia permanently deletes media from every team that has been
+// in the trash for longer than the given age, for the background purge. It
+// returns the number of items purged.
+func PurgeDeletedMedia(ctx context.Context, age time.Duration) (int, error) {
+	media := Library{}
+	err := db.NewSelect().
+		Model(&media).
+		WhereDeleted().
+		Where("deleted_at < ?", time.Now().Add(-age)).
+		Scan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return media.purge(ctx)
+}
+
+// EmptyTrash permanently deletes the media in the trash that the user in
+// the context can see. It returns the number of items purged.
+func EmptyTrash(ctx context.Context) (int, error) {
+	media, err := FindDeletedMedia(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return media.purge(ctx)
+}
+
+// purge permanently deletes the media, stopping at the first error
+func (l Library) purge(ctx context.Context) (int, error) {
+	for i, m := range l {
+		if err := m.Purge(ctx); err != nil {
+			return i, err
+		}
+	}
+	return len(l), nil
+}
+
+// WasMediaDeleted reports whether media with the given ID is in the trash
+func WasMediaDeleted(ctx context.Context, id string) bool {
+	exists, err := db.NewSelect().
+		Model((*Media)(nil)).
+		WhereDeleted().
+		Where("id = ?", id).
+		Exists(ctx)
+	return err == nil && exists
+}
+
+// StartTrashPurge periodically purges media that has been in the trash for
+// longer than the configured retention. A retention of 0 disables the purge.
+func StartTrashPurge() {
+	age := settings.TrashRetention()
+	if age <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			ctx, span := tracing.Span(context.Background(), "job.purge_trash")
+			n, err := PurgeDeletedMedia(ctx, age)
+			span.SetAttributes(attribute.Int("purged", n))
+			tracing.End(span, err)
+			if err != nil {
+				log.Error("Error purging trash", "err", err)
+			} else if n > 0 {
+				log.Info("Purged media from the trash", "count", n)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/nathanhollows/ace-video/helpers"
+	"github.com/uptrace/bun"
+)
+
+// recoveryCodeCount is how many recovery codes a user is given
+const recoveryCodeCount = 10
+
+// RecoveryCode is a single use code for signing in without the
+// authenticator app. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID     string    `bun:",pk,type:varchar(36)"`
+	UserID string    `bun:",notnull,type:varchar(36)"`
+	Hash   string    `bun:",unique,type:varchar(64)"`
+	UsedAt time.Time `bun:",nullzero"`
+}
+
+var ErrInvalidCode = NewUserError("that code is not valid")
+
+// StartTOTPEnrolment gives the user a new secret to add to their
+// authenticator app. Two factor authentication is not enabled until a
+// code has been confirmed with EnableTOTP.
+func (u *User) StartTOTPEnrolment(ctx context.Context) error {
+	if u.TOTPEnabled {
+		return NewConflictError("two factor authentication is already enabled")
+	}
+	secret, err := helpers.NewTOTPSecret()
+	if err != nil {
+		return err
+	}
+	u.TOTPSecret = secret
+	_, err = db.NewUpdate().
+		Model(u).
+		Column("totp_secret").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+// TOTPURI returns the otpauth URI to show as a QR code during enrolment
+func (u *User) TOTPURI() string {
+	return helpers.TOTPURI(settings.AppName, u.Email, u.TOTPSecret)
+}
+
+// EnableTOTP turns on two factor authentication once the user has entered
+// a code from their app. It returns a fresh set of recovery codes.
+func (u *User) EnableTOTP(ctx context.Context, code string) ([]string, error) {
+	if u.TOTPSecret == "" {
+		return nil, NewUserError("two factor authentication has not been set up")
+	}
+	step, ok := helpers.ValidateTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	var codes []string
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		u.TOTPEnabled = true
+		u.TOTPLastStep = step
+		_, err := tx.NewUpdate().
+			Model(u).
+			Column("totp_enabled", "totp_last_step").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		codes, err = newRecoveryCodes(ctx, tx, u.ID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP turns off two factor authentication and removes the secret
+// and recovery codes
+func (u *User) DisableTOTP(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		u.TOTPEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastStep = 0
+		_, err := tx.NewUpdate().
+			Model(u).
+			Column("totp_enabled", "totp_secret", "totp_last_step").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.NewDelete().
+			Model((*RecoveryCode)(nil)).
+			Where("user_id = ?", u.ID).
+			Exec(ctx)
+		return err
+	})
+}
+
+// VerifySecondFactor checks a code from the authenticator app or an unused
+// recovery code. Each app code and recovery code only works once. Wrong
+// codes count towards locking the account, like wrong passwords.
+func (u *User) VerifySecondFactor(ctx context.Context, code string) error {
+	if u.IsLocked() {
+		return ErrInvalidCode
+	}
+	err := u.checkSecondFactor(ctx, code)
+	if errors.Is(err, ErrInvalidCode) {
+		if err := u.recordFailedLogin(ctx); err != nil {
+			log.FromContext(ctx).Error("Error recording failed login", "err", err)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if u.FailedLogins > 0 {
+		if err := u.Unlock(ctx); err != nil {
+			log.FromContext(ctx).Error("Error resetting failed logins", "err", err)
+		}
+	}
+	return nil
+}
+
+// checkSecondFactor checks and uses up the code
+func (u *User) checkSecondFactor(ctx context.Context, code string) error {
+	if !u.TOTPEnabled {
+		return NewUserError("two factor authentication is not enabled")
+	}
+
+	if step, ok := helpers.ValidateTOTP(u.TOTPSecret, code, time.Now()); ok {
+		res, err := db.NewUpdate().
+			Model((*User)(nil)).
+			Set("totp_last_step = ?", step).
+			Where("id = ?", u.ID).
+			Where("totp_last_step < ?", step).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			// The code has already been used
+			return ErrInvalidCode
+		}
+		u.TOTPLastStep = step
+		return nil
+	}
+
+	res, err := db.NewUpdate().
+		Model((*RecoveryCode)(nil)).
+		Set("used_at = ?", time.Now()).
+		Where("user_id = ?", u.ID).
+		Where("hash = ?", hashToken(normaliseRecoveryCode(code))).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (u *User) RegenerateRecoveryCodes(ctx context.Context) ([]string, error) {
+	if !u.TOTPEnabled {
+		return nil, NewUserError("two factor authentication is not enabled")
+	}
+	var codes []string
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		codes, err = newRecoveryCodes(ctx, tx, u.ID)
+		return err
+	})
+	return codes, err
+}
+
+// RemainingRecoveryCodes counts the user's unused recovery codes
+func (u *User) RemainingRecoveryCodes(ctx context.Context) (int, error) {
+	return db.NewSelect().
+		Model((*RecoveryCode)(nil)).
+		Where("user_id = ?", u.ID).
+		Where("used_at IS NULL").
+		Count(ctx)
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new
+// codes to show to the user once
+func newRecoveryCodes(ctx context.Context, tx bun.Tx, userID string) ([]string, error) {
+	_, err := tx.NewDelete().
+		Model((*RecoveryCode)(nil)).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]*RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = &RecoveryCode{
+			ID:     uuid.New().String(),
+			UserID: userID,
+			Hash:   hashToken(normaliseRecoveryCode(code)),
+		}
+	}
+	_, err = tx.NewInsert().Model(&rows).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// recoveryAlphabet leaves out characters that are easily confused
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// randomRecoveryCode returns a code like "k7rp2-x9qmd"
+func randomRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = recoveryAlphabet[int(b[i])%len(recoveryAlphabet)]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// normaliseRecoveryCode ignores case, spaces and dashes
+func normaliseRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -3,20 +3,74 @@ package models
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/log"
 	"github.com/google/uuid"
-	"github.com/nathanhollows/ace-video/sessions"
+	"github.com/nathanhollows/ace-video/oidc"
+	"github.com/nathanhollows/ace-video/ratelimit"
+	"github.com/uptrace/bun"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Role controls what a user can do in the admin
+type Role string
+
+const (
+	// RoleViewer can browse media and print cards
+	RoleViewer Role = "viewer"
+	// RoleEditor can also upload and edit media and collections
+	RoleEditor Role = "editor"
+	// RoleOwner can also manage users
+	RoleOwner Role = "owner"
+)
+
+// Roles lists the roles from least to most privileged
+var Roles = []Role{RoleViewer, RoleEditor, RoleOwner}
+
+// rank orders roles so that each includes the ones below it
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
 type User struct {
 	baseModel
 
 	ID       string `bun:",pk,type:varchar(36)" json:"user_id"`
 	Email    string `bun:",unique,pk" json:"email"`
 	Password string `bun:",type:varchar(255)" json:"password"`
+	// Accounts created before roles existed are made owners by InitDB
+	Role     Role `bun:",type:varchar(16),notnull,default:'viewer'" json:"role"`
+	Disabled bool `bun:",notnull,default:false" json:"disabled"`
+	// OIDCSubject links the user to their single sign-on identity
+	OIDCSubject string `bun:"oidc_subject,nullzero,type:varchar(255)" json:"-"`
+
+	// Two factor authentication. The secret is set during enrolment and
+	// only used once TOTPEnabled is set. Owners can require it.
+	TOTPSecret   string `bun:",type:varchar(64)" json:"-"`
+	TOTPEnabled  bool   `bun:",notnull,default:false" json:"-"`
+	TOTPRequired bool   `bun:",notnull,default:false" json:"-"`
+	TOTPLastStep int64  `bun:",notnull,default:0" json:"-"`
+
+	// Failed sign ins since the last successful one
+	FailedLogins int       `bun:",notnull,default:0" json:"-"`
+	LockedUntil  time.Time `bun:",nullzero" json:"-"`
 }
 
 type Users []*User
@@ -26,10 +80,22 @@ func NewUser(email, password string) (user *User) {
 	user = &User{}
 	user.ID = uuid.New().String()
 	user.Email = email
+	user.Role = RoleViewer
 	user.SetPassword(password)
 	return user
 }
 
+// HasRole reports whether the user has at least the given role
+func (u *User) HasRole(role Role) bool {
+	return !u.Disabled && u.Role.rank() >= role.rank()
+}
+
+// IsPending reports whether the user has been invited but has not yet
+// set a password or signed in with single sign-on
+func (u *User) IsPending() bool {
+	return u.Password == "" && u.OIDCSubject == ""
+}
+
 // Save the user to the database
 func (u *User) Save() error {
 	ctx := context.Background()
@@ -40,22 +106,297 @@ func (u *User) Save() error {
 	return nil
 }
 
-// AuthenticateUser checks the user's credentials and returns the user if they are valid
-func AuthenticateUser(email, password string) (*User, error) {
+// Update saves changes to an existing user
+func (u *User) Update(ctx context.Context) error {
+	_, err := db.NewUpdate().
+		Model(u).
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+// Delete removes the user along with any outstanding tokens, sessions,
+// team memberships, recovery codes and API tokens
+func (u *User) Delete(ctx context.Context) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, model := range []interface{}{(*UserToken)(nil), (*UserSession)(nil), (*TeamMember)(nil), (*RecoveryCode)(nil), (*APIToken)(nil)} {
+			_, err := tx.NewDelete().
+				Model(model).
+				Where("user_id = ?", u.ID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.NewDelete().
+			Model(u).
+			WherePK().
+			ForceDelete().
+			Exec(ctx)
+		return err
+	})
+}
+
+// InviteUser creates a user without a password and returns the token they
+// use to set one
+func InviteUser(ctx context.Context, email string, role Role) (*User, string, error) {
+	if !role.Valid() {
+		return nil, "", NewUserError("unknown role")
+	}
+	email, err := ValidateEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := FindUserByEmail(email); err == nil {
+		return nil, "", NewConflictError("a user with that email already exists")
+	}
+
+	user := &User{
+		ID:    uuid.New().String(),
+		Email: email,
+		Role:  role,
+	}
+	if err := user.Save(); err != nil {
+		return nil, "", err
+	}
+	token, err := NewUserToken(ctx, user.ID, TokenInvite, 7*24*time.Hour)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// FindAllUsers returns every user ordered by email
+func FindAllUsers(ctx context.Context) (Users, error) {
+	var users Users
+	err := db.NewSelect().
+		Model(&users).
+		Order("email ASC").
+		Scan(ctx)
+	return users, err
+}
+
+// minPasswordLength is the fewest characters a password may have
+const minPasswordLength = 12
+
+// commonPasswords are rejected even when they are long enough
+var commonPasswords = []string{
+	"password1234", "123456789012", "qwertyuiopas", "iloveyou1234",
+	"passwordpassword", "administrator", "letmein12345", "welcome12345",
+}
+
+// ValidateEmail checks the email address and returns it normalised
+func ValidateEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "", NewUserError("please enter a valid email address")
+	}
+	return email, nil
+}
+
+// ValidatePassword checks that the password is strong enough for the user
+// with the given email
+func ValidatePassword(password, email string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return NewUserError(fmt.Sprintf("passwords must be at least %d characters long", minPasswordLength))
+	}
+	// bcrypt ignores anything past 72 bytes
+	if len(password) > 72 {
+		return NewUserError("passwords must be at most 72 bytes long")
+	}
+	lower := strings.ToLower(password)
+	if name, _, ok := strings.Cut(strings.ToLower(email), "@"); ok && len(name) >= 4 && strings.Contains(lower, name) {
+		return NewUserError("passwords must not contain your email address")
+	}
+	for _, common := range commonPasswords {
+		if lower == common {
+			return NewUserError("that password is too common")
+		}
+	}
+	if strings.Count(password, string([]rune(password)[0])) == utf8.RuneCountInString(password) {
+		return NewUserError("passwords must not repeat a single character")
+	}
+	return nil
+}
+
+// ErrInvalidCredentials is returned for any failed sign in, so that
+// responses do not reveal which accounts exist or are locked
+var ErrInvalidCredentials = NewUnauthorizedError("invalid email or password")
+
+const (
+	// lockoutThreshold is how many failed sign ins lock an account
+	lockoutThreshold = 5
+	// lockoutBase is how long the first lockout lasts. Each further failure
+	// doubles it, up to lockoutMax.
+	lockoutBase = time.Minute
+	lockoutMax  = 24 * time.Hour
+)
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// compareDummyHash spends as long as checking a real password, so unknown
+// emails cannot be told apart by timing
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash = []byte(hashAndSalt(uuid.New().String()))
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// AuthenticateUser checks the user's credentials and returns the user if they are valid.
+// Repeated failures lock the account for exponentially longer periods.
+func AuthenticateUser(ctx context.Context, email, password string) (*User, error) {
 	// Find the user by email
 	user, err := FindUserByEmail(email)
-	if err != nil {
-		log.Error("Error finding user: ", err)
-		return nil, err
+	if err != nil || user.Password == "" {
+		compareDummyHash(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	if user.Disabled || user.IsLocked() {
+		compareDummyHash(password)
+		return nil, ErrInvalidCredentials
 	}
 
 	// Check the password
-	if !user.checkPassword(password) {
-		log.Error("Invalid password")
-		return nil, errors.New("invalid password")
-	} else {
+	if !user.CheckPassword(password) {
+		if err := user.recordFailedLogin(ctx); err != nil {
+			log.FromContext(ctx).Error("Error recording failed login", "err", err)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// Users with a second factor are only unlocked once it is checked, so
+	// knowing the password does not reset failed codes
+	if user.FailedLogins > 0 && !user.TOTPEnabled {
+		if err := user.Unlock(ctx); err != nil {
+			log.FromContext(ctx).Error("Error resetting failed logins", "err", err)
+		}
+	}
+	return user, nil
+}
+
+// AuthenticateOIDC signs in the user verified by the single sign-on
+// provider. Users are found by their provider subject, then by verified
+// email, and created on first sign in. A role mapped from the provider's
+// claims replaces the user's role each time they sign in.
+func AuthenticateOIDC(ctx context.Context, identity *oidc.Identity) (*User, error) {
+	role := Role("")
+	for _, r := range identity.Roles {
+		if Role(r).rank() > role.rank() {
+			role = Role(r)
+		}
+	}
+
+	user := &User{}
+	err := db.NewSelect().
+		Model(user).
+		Where("oidc_subject = ?", identity.Subject).
+		Scan(ctx)
+	if err != nil {
+		user, err = FindUserByEmail(identity.Email)
+		// Anyone could claim an address the provider has not verified
+		if err == nil && !identity.EmailVerified {
+			return nil, NewUserError("your email address must be verified with the provider before it can be linked to your account")
+		}
+	}
+	if err != nil {
+		// Create the user the first time they sign in
+		if !role.Valid() {
+			role = Role(oidc.DefaultRole())
+		}
+		if !role.Valid() {
+			return nil, errors.New("unknown default role for single sign-on users")
+		}
+		user = &User{
+			ID:          uuid.New().String(),
+			Email:       identity.Email,
+			Role:        role,
+			OIDCSubject: identity.Subject,
+		}
+		if err := user.Save(); err != nil {
+			return nil, err
+		}
+		log.FromContext(ctx).Info("Created user from single sign-on", "user", user.ID, "role", user.Role)
 		return user, nil
 	}
+
+	if user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	if user.OIDCSubject != "" && user.OIDCSubject != identity.Subject {
+		return nil, NewUserError("this account is linked to a different single sign-on identity")
+	}
+	// The provider's address may belong to another account here
+	if user.Email != identity.Email {
+		taken, err := db.NewSelect().
+			Model((*User)(nil)).
+			Where("email = ?", identity.Email).
+			Where("id != ?", user.ID).
+			Exists(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, NewConflictError("another account already uses the email address " + identity.Email)
+		}
+	}
+	user.OIDCSubject = identity.Subject
+	user.Email = identity.Email
+	if role.Valid() && role != user.Role {
+		log.FromContext(ctx).Info("Updated user role from single sign-on", "user", user.ID, "from", user.Role, "to", role)
+		user.Role = role
+	}
+	// Email is part of the primary key, which bun leaves out of Column
+	_, err = db.NewUpdate().
+		Model((*User)(nil)).
+		Set("oidc_subject = ?", user.OIDCSubject).
+		Set("email = ?", user.Email).
+		Set("role = ?", user.Role).
+		Where("id = ?", user.ID).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// IsLocked reports whether the account is locked after failed sign ins
+func (u *User) IsLocked() bool {
+	return time.Now().Before(u.LockedUntil)
+}
+
+// recordFailedLogin counts a failed sign in and locks the account once
+// there have been too many
+func (u *User) recordFailedLogin(ctx context.Context) error {
+	u.FailedLogins++
+	if u.FailedLogins >= lockoutThreshold {
+		delay := ratelimit.Delay(u.FailedLogins-lockoutThreshold, lockoutBase, lockoutMax)
+		u.LockedUntil = time.Now().Add(delay)
+		log.FromContext(ctx).Warn("Account locked after failed logins", "user", u.ID, "until", u.LockedUntil)
+	}
+	_, err := db.NewUpdate().
+		Model(u).
+		Column("failed_logins", "locked_until").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+// Unlock clears failed sign ins so the user can sign in straight away
+func (u *User) Unlock(ctx context.Context) error {
+	u.FailedLogins = 0
+	u.LockedUntil = time.Time{}
+	_, err := db.NewUpdate().
+		Model(u).
+		Column("failed_logins", "locked_until").
+		WherePK().
+		Exec(ctx)
+	return err
 }
 
 // FindUserByEmail finds a user by their email address
@@ -89,13 +430,9 @@ func FindUserByID(userID string) (*User, error) {
 }
 
 // CheckPassword checks if the given password is correct
-func (u *User) checkPassword(password string) bool {
+func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	if err != nil {
-		log.Error("Error comparing password: ", err)
-		return false
-	}
-	return true
+	return err == nil
 }
 
 // SetPassword sets the user's password
@@ -103,11 +440,34 @@ func (u *User) SetPassword(password string) {
 	u.Password = hashAndSalt(password)
 }
 
+// ChangePassword saves a new password and signs the user out of every
+// session except the one given, which may be empty
+func (u *User) ChangePassword(ctx context.Context, password, keepSessionID string) error {
+	u.SetPassword(password)
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return u.savePassword(ctx, tx, keepSessionID)
+	})
+}
+
+// savePassword stores the password set on the user and signs out their
+// other sessions
+func (u *User) savePassword(ctx context.Context, tx bun.Tx, keepSessionID string) error {
+	_, err := tx.NewUpdate().
+		Model(u).
+		Column("password").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return revokeUserSessions(ctx, tx, u.ID, keepSessionID)
+}
+
 // hashAndSalt hashes and salts the given password
 func hashAndSalt(password string) string {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Error("Error hashing password: ", err)
+		log.Error("Error hashing password", "err", err)
 	}
 
 	return string(hash)
@@ -115,25 +475,11 @@ func hashAndSalt(password string) string {
 
 // FindUserBySession finds the user by the session
 func FindUserBySession(r *http.Request) (*User, error) {
-	// Get the session
-	session, err := sessions.Get(r, "admin")
+	session, err := FindSessionByRequest(r)
 	if err != nil {
 		return nil, err
 	}
-
-	// Get the user id from the session
-	userID, ok := session.Values["user_id"].(string)
-	if !ok {
-		return nil, errors.New("User not found")
-	}
-
-	// Find the user by the user id
-	user, err := FindUserByID(userID)
-	if err != nil {
-		return nil, err
-	}
-
-	return user, nil
+	return session.User, nil
 }
 
 // CheckAnyUsers checks if any users exist
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/nathanhollows/ace-video/config"
+	"github.com/nathanhollows/ace-video/oidc"
+)
+
+// useTestDB connects the models to a new SQLite database for the test
+func useTestDB(t *testing.T) {
+	t.Helper()
+	InitDB(&config.Config{
+		DB: config.DB{
+			Type:       "sqlite3",
+			Connection: "file:" + filepath.Join(t.TempDir(), "test.db"),
+		},
+	})
+	t.Cleanup(func() { CloseDB() })
+}
+
+// addUser saves a user for the test
+func addUser(t *testing.T, user *User) *User {
+	t.Helper()
+	if user.ID == "" {
+		user.ID = user.Email
+	}
+	if err := user.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestAuthenticateOIDCCreatesUsers(t *testing.T) {
+	oidc.Start(config.OIDC{DefaultRole: "viewer"})
+	defer oidc.Start(config.OIDC{})
+
+	tests := []struct {
+		name  string
+		roles []string
+		want  Role
+	}{
+		{"default role", nil, RoleViewer},
+		{"mapped role", []string{"editor"}, RoleEditor},
+		{"highest mapped role", []string{"editor", "owner", "viewer"}, RoleOwner},
+		{"unknown mapped role", []string{"admin"}, RoleViewer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			identity := &oidc.Identity{Subject: "subject-1", Email: "new@example.com", Roles: tt.roles}
+
+			user, err := AuthenticateOIDC(context.Background(), identity)
+			if err != nil {
+				t.Fatalf("AuthenticateOIDC() error = %v", err)
+			}
+			if user.Role != tt.want || user.Email != "new@example.com" || user.OIDCSubject != "subject-1" {
+				t.Errorf("AuthenticateOIDC() = %s %s %s, want %s", user.Email, user.OIDCSubject, user.Role, tt.want)
+			}
+			saved, err := FindUserByEmail("new@example.com")
+			if err != nil {
+				t.Fatalf("the user was not saved: %v", err)
+			}
+			if saved.ID != user.ID || saved.Role != tt.want {
+				t.Errorf("saved user = %s %s, want %s %s", saved.ID, saved.Role, user.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateOIDCLinksByEmail(t *testing.T) {
+	useTestDB(t)
+	existing := addUser(t, &User{Email: "staff@example.com", Role: RoleEditor})
+
+	identity := &oidc.Identity{Subject: "subject-1", Email: "staff@example.com"}
+	if user, err := AuthenticateOIDC(context.Background(), identity); err == nil {
+		t.Fatalf("AuthenticateOIDC() = %s, want an unverified address refused", user.ID)
+	}
+
+	identity.EmailVerified = true
+	user, err := AuthenticateOIDC(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateOIDC() error = %v", err)
+	}
+	if user.ID != existing.ID {
+		t.Fatalf("AuthenticateOIDC() = user %s, want the existing user %s", user.ID, existing.ID)
+	}
+	// Without a mapped role the user keeps the role they have
+	if user.Role != RoleEditor {
+		t.Errorf("Role = %s, want %s", user.Role, RoleEditor)
+	}
+	saved, err := FindUserByID(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.OIDCSubject != "subject-1" {
+		t.Errorf("OIDCSubject = %q, want the user linked to subject-1", saved.OIDCSubject)
+	}
+}
+
+func TestAuthenticateOIDCFindsBySubject(t *testing.T) {
+	useTestDB(t)
+	linked := addUser(t, &User{Email: "old@example.com", Role: RoleViewer, OIDCSubject: "subject-1"})
+	// Another account has the new address, but the subject decides
+	addUser(t, &User{Email: "new@example.com", Role: RoleViewer})
+
+	identity := &oidc.Identity{Subject: "subject-1", Email: "new@example.com", Roles: []string{"owner"}}
+	user, err := AuthenticateOIDC(context.Background(), identity)
+	if err == nil {
+		t.Fatalf("AuthenticateOIDC() = %s, want the duplicate email refused", user.ID)
+	}
+
+	identity.Email = "renamed@example.com"
+	user, err = AuthenticateOIDC(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateOIDC() error = %v", err)
+	}
+	if user.ID != linked.ID {
+		t.Fatalf("AuthenticateOIDC() = user %s, want the linked user %s", user.ID, linked.ID)
+	}
+	saved, err := FindUserByID(linked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Email != "renamed@example.com" || saved.Role != RoleOwner {
+		t.Errorf("saved user = %s %s, want the provider's email and mapped role", saved.Email, saved.Role)
+	}
+}
+
+func TestAuthenticateOIDCRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		user *User
+	}{
+		{"linked to another subject", &User{Email: "staff@example.com", Role: RoleOwner, OIDCSubject: "subject-2"}},
+		{"disabled by email", &User{Email: "staff@example.com", Role: RoleOwner, Disabled: true}},
+		{"disabled by subject", &User{Email: "other@example.com", Role: RoleOwner, OIDCSubject: "subject-1", Disabled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestDB(t)
+			addUser(t, tt.user)
+
+			identity := &oidc.Identity{Subject: "subject-1", Email: "staff@example.com", EmailVerified: true, Roles: []string{"owner"}}
+			user, err := AuthenticateOIDC(context.Background(), identity)
+			if err == nil {
+				t.Fatalf("AuthenticateOIDC() = %s, want an error", user.ID)
+			}
+			saved, err := FindUserByID(tt.user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.OIDCSubject != tt.user.OIDCSubject {
+				t.Errorf("OIDCSubject = %q, want it unchanged", saved.OIDCSubject)
+			}
+		})
+	}
+}
+
+func TestInviteUserValidatesEmail(t *testing.T) {
+	useTestDB(t)
+
+	for _, email := range []string{"not-an-email", "staff@example.com\r\nBcc: other@example.com", "Staff <staff@example.com>"} {
+		if _, _, err := InviteUser(context.Background(), email, RoleEditor); err == nil {
+			t.Errorf("InviteUser(%q) worked, want an invalid address", email)
+		}
+	}
+
+	user, token, err := InviteUser(context.Background(), " Staff@Example.COM ", RoleEditor)
+	if err != nil {
+		t.Fatalf("InviteUser() error = %v", err)
+	}
+	if user.Email != "staff@example.com" || token == "" {
+		t.Errorf("InviteUser() = %q with token %q, want the address normalised", user.Email, token)
+	}
+	if _, _, err := InviteUser(context.Background(), "STAFF@example.com", RoleViewer); err == nil {
+		t.Error("InviteUser() invited the same address twice")
+	}
+}
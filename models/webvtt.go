@@ -0,0 +1,129 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cue is a single timed block of text from a WebVTT file
+type Cue struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type Cues []Cue
+
+var (
+	vttTagPattern   = regexp.MustCompile(`<[^>]*>`)
+	vttBlockPattern = regexp.MustCompile(`\n{2,}`)
+)
+
+// ParseWebVTT reads the cues from a WebVTT document.
+// Cue text is returned as plain text with any markup removed.
+func ParseWebVTT(content string) (Cues, error) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if !strings.HasPrefix(content, "WEBVTT") {
+		return nil, NewUserError("missing WEBVTT header")
+	}
+
+	cues := Cues{}
+	// Blocks are separated by one or more blank lines
+	for _, block := range vttBlockPattern.Split(content, -1)[1:] {
+		scanner := bufio.NewScanner(strings.NewReader(strings.TrimSpace(block)))
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if len(lines) == 0 || strings.HasPrefix(lines[0], "NOTE") ||
+			lines[0] == "STYLE" || lines[0] == "REGION" {
+			continue
+		}
+
+		// The identifier line is optional
+		if !strings.Contains(lines[0], "-->") {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 || !strings.Contains(lines[0], "-->") {
+			return nil, NewUserError(fmt.Sprintf("cue is missing timings: %q", block))
+		}
+
+		timings := strings.SplitN(lines[0], "-->", 2)
+		start, err := parseVTTTimestamp(timings[0])
+		if err != nil {
+			return nil, err
+		}
+		// Cue settings may follow the end timestamp
+		end, err := parseVTTTimestamp(strings.Fields(timings[1] + " ")[0])
+		if err != nil {
+			return nil, err
+		}
+
+		text := vttTagPattern.ReplaceAllString(strings.Join(lines[1:], " "), "")
+		cues = append(cues, Cue{
+			Start: start,
+			End:   end,
+			Text:  strings.TrimSpace(html.UnescapeString(text)),
+		})
+	}
+	return cues, nil
+}
+
+// Text joins the text of every cue into a single string
+func (c Cues) Text() string {
+	text := make([]string, len(c))
+	for i, cue := range c {
+		text[i] = cue.Text
+	}
+	return strings.Join(text, "\n")
+}
+
+// Find returns the first cue containing the term, ignoring case
+func (c Cues) Find(term string) (Cue, bool) {
+	term = strings.ToLower(term)
+	for _, cue := range c {
+		if strings.Contains(strings.ToLower(cue.Text), term) {
+			return cue, true
+		}
+	}
+	return Cue{}, false
+}
+
+// parseVTTTimestamp parses a WebVTT timestamp, e.g. 01:02.500 or 00:01:02.500
+func parseVTTTimestamp(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, NewUserError(fmt.Sprintf("invalid timestamp: %q", s))
+	}
+	var seconds float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, NewUserError(fmt.Sprintf("invalid timestamp: %q", s))
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp (hh:mm:ss.ttt)
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
+}
+
+// vttEscape escapes cue text so it cannot break out of the cue
+func vttEscape(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,328 @@
+// Package oidc signs staff in through an OpenID Connect identity provider
+// using the authorization code flow with PKCE. The provider, email domain
+// allow-list and role mapping are set in the config package.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathanhollows/ace-video/config"
+)
+
+// Identity is the verified user returned by the provider
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+	// EmailVerified is set when the provider says it verified the email.
+	// Only verified addresses are linked to existing accounts.
+	EmailVerified bool
+	// Roles are the roles mapped from the role claim
+	Roles []string
+}
+
+// ErrNotAllowed is returned when the user's email domain is not allowed
+var ErrNotAllowed = errors.New("your email address is not allowed to sign in")
+
+// ErrUnverified is returned when the provider has not verified the user's
+// email address but it needs to be trusted
+var ErrUnverified = errors.New("your email address has not been verified with the provider")
+
+var (
+	settings config.OIDC
+	provider *Provider
+)
+
+// Start configures single sign-on. It is left disabled when the settings
+// do not name a provider.
+func Start(c config.OIDC) {
+	settings = c
+	provider = nil
+	if c.Enabled() {
+		provider = NewProvider(c)
+	}
+}
+
+// Enabled reports whether single sign-on is configured
+func Enabled() bool {
+	return provider != nil
+}
+
+// ProviderName is the name shown on the sign in button
+func ProviderName() string {
+	return settings.ProviderName
+}
+
+// DefaultRole is the role given to new users without a mapped role
+func DefaultRole() string {
+	return settings.DefaultRole
+}
+
+// Login holds the values that tie a callback to the browser that started
+// the sign in. They are kept in the browser's session until the callback.
+type Login struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewLogin creates the random values for a new sign in
+func NewLogin() (*Login, error) {
+	values := make([]string, 3)
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &Login{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthCodeURL is where to send the browser to sign in with the provider
+func AuthCodeURL(ctx context.Context, login *Login) (string, error) {
+	if provider == nil {
+		return "", errors.New("single sign-on is not configured")
+	}
+	return provider.AuthCodeURL(ctx, login)
+}
+
+// Exchange swaps the code from the callback for the user's identity
+func Exchange(ctx context.Context, code string, login *Login) (*Identity, error) {
+	if provider == nil {
+		return nil, errors.New("single sign-on is not configured")
+	}
+	return provider.Exchange(ctx, code, login)
+}
+
+// Provider talks to an OpenID Connect identity provider
+type Provider struct {
+	config config.OIDC
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+// discovery is the subset of the provider's metadata that we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider creates a provider. Its metadata is fetched on first use so
+// that the server can start while the provider is unavailable.
+func NewProvider(c config.OIDC) *Provider {
+	return &Provider{
+		config: c,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// metadata fetches and caches the provider's discovery document
+func (p *Provider) metadata(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &discovery{}
+	err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, fmt.Errorf("fetching provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("provider issuer %q does not match %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("provider metadata is missing endpoints")
+	}
+	p.discovery = d
+	p.keys = newKeySet(d.JWKSURI, p.getJSON)
+	return d, nil
+}
+
+// AuthCodeURL builds the authorization request with a PKCE challenge
+func (p *Provider) AuthCodeURL(ctx context.Context, login *Login) (string, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", login.State)
+	q.Set("nonce", login.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems the authorization code, verifies the ID token and
+// applies the domain allow-list and role mapping
+func (p *Provider) Exchange(ctx context.Context, code string, login *Login) (*Identity, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {login.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("redeeming code: %w", err)
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("reading token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %d: %s %s", res.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	claims, err := p.verify(ctx, token.IDToken, login.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("verifying id_token: %w", err)
+	}
+	return p.identity(claims)
+}
+
+// identity checks the verified claims and maps them to a user
+func (p *Provider) identity(claims map[string]interface{}) (*Identity, error) {
+	id := &Identity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	id.Email = strings.ToLower(strings.TrimSpace(id.Email))
+	if id.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	if id.Email == "" {
+		return nil, errors.New("the provider did not share an email address")
+	}
+	// Unverified addresses could be used to take over an existing account
+	verified, ok := claims["email_verified"]
+	id.EmailVerified = verified == true || verified == "true"
+	if ok && !id.EmailVerified {
+		return nil, ErrUnverified
+	}
+	if !p.allowed(id.Email) {
+		return nil, ErrNotAllowed
+	}
+	// Anyone could claim an address on an allowed domain
+	if len(p.config.AllowedDomains) > 0 && !id.EmailVerified {
+		return nil, ErrUnverified
+	}
+	id.Roles = p.roles(claims)
+	return id, nil
+}
+
+// allowed reports whether the email's domain is on the allow-list
+func (p *Provider) allowed(email string) bool {
+	if len(p.config.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range p.config.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// roles maps the values of the role claim, which may be a single string
+// or a list, to role names
+func (p *Provider) roles(claims map[string]interface{}) []string {
+	if p.config.RoleClaim == "" {
+		return nil
+	}
+	var values []string
+	switch v := claims[p.config.RoleClaim].(type) {
+	case string:
+		values = append(values, v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var roles []string
+	for _, value := range values {
+		if role, ok := p.config.RoleMap[value]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// getJSON fetches a JSON document from the provider
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,418 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nathanhollows/ace-video/config"
+)
+
+const (
+	testClientID = "ace-video"
+	testCode     = "code-from-the-provider"
+)
+
+// mockProvider is a local OpenID Connect provider. It redeems testCode for
+// an ID token with its claims, after checking the PKCE verifier against the
+// challenge from the authorization request.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	// issuer is sent in the discovery document
+	issuer string
+	// challenge is the PKCE challenge the browser was sent with
+	challenge string
+	// claims are signed into the ID token
+	claims map[string]interface{}
+	// signer signs tokens instead of key, to test bad signatures
+	signer *rsa.PrivateKey
+}
+
+// providerKey signs the mock providers' tokens. Generating a key is slow,
+// so the tests share one.
+var (
+	providerKey     *rsa.PrivateKey
+	providerKeyOnce sync.Once
+)
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	providerKeyOnce.Do(func() { providerKey = newKey(t) })
+	m := &mockProvider{t: t, key: providerKey, kid: "key-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	m.issuer = m.server.URL
+	return m
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 m.issuer,
+		"authorization_endpoint": m.server.URL + "/authorize",
+		"token_endpoint":         m.server.URL + "/token",
+		"jwks_uri":               m.server.URL + "/jwks",
+	})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": m.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		m.t.Errorf("token request: %v", err)
+	}
+	fail := func(reason string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": reason})
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != testCode {
+		fail("invalid_grant")
+		return
+	}
+	if r.PostForm.Get("client_id") != testClientID {
+		fail("invalid_client")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+		fail("invalid_grant")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     m.sign(m.claims),
+	})
+}
+
+// sign encodes the claims as an RS256 JWT
+func (m *mockProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": m.kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signer := m.key
+	if m.signer != nil {
+		signer = m.signer
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims are the claims of a token the provider would issue for login
+func (m *mockProvider) validClaims(login *Login) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.issuer,
+		"sub":            "subject-1",
+		"aud":            testClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          login.Nonce,
+		"email":          "Staff@Example.com",
+		"email_verified": true,
+		"name":           "Staff Member",
+	}
+}
+
+// config is the single sign-on configuration for the mock provider
+func (m *mockProvider) config() config.OIDC {
+	return config.OIDC{
+		Issuer:      m.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://ace.example.com/login/oidc/callback",
+		DefaultRole: "viewer",
+	}
+}
+
+// start begins a sign in, as the login handler does, and records the PKCE
+// challenge the browser would carry to the provider
+func (m *mockProvider) start(p *Provider) *Login {
+	m.t.Helper()
+	login, err := NewLogin()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(context.Background(), login)
+	if err != nil {
+		m.t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.challenge = u.Query().Get("code_challenge")
+	return login
+}
+
+func TestNewLogin(t *testing.T) {
+	a, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.State == "" || a.Nonce == "" || a.Verifier == "" {
+		t.Fatalf("NewLogin() = %+v, want every value set", a)
+	}
+	if a.State == a.Nonce || a.Nonce == a.Verifier || *a == *b {
+		t.Errorf("NewLogin() values are reused: %+v and %+v", a, b)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	p := NewProvider(m.config())
+	login := &Login{State: "the-state", Nonce: "the-nonce", Verifier: "the-verifier"}
+
+	authURL, err := p.AuthCodeURL(context.Background(), login)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	if !strings.HasPrefix(authURL, m.server.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL() = %q, want the authorization endpoint", authURL)
+	}
+	u, _ := url.Parse(authURL)
+	challenge := sha256.Sum256([]byte("the-verifier"))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "https://ace.example.com/login/oidc/callback",
+		"scope":                 "openid email profile",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := u.Query().Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestMetadataIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	m.issuer = "https://attacker.example.com"
+	p := NewProvider(m.config())
+
+	_, err := p.AuthCodeURL(context.Background(), &Login{})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("AuthCodeURL() error = %v, want an issuer mismatch", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	p := NewProvider(m.config())
+	login := m.start(p)
+	m.claims = m.validClaims(login)
+
+	identity, err := p.Exchange(context.Background(), testCode, login)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	want := &Identity{Subject: "subject-1", Email: "staff@example.com", Name: "Staff Member", EmailVerified: true}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("Exchange() = %+v, want %+v", identity, want)
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	m := newMockProvider(t)
+	p := NewProvider(m.config())
+	login := m.start(p)
+	m.claims = m.validClaims(login)
+
+	// A code intercepted on its way to another browser is useless without
+	// that browser's verifier
+	stolen := *login
+	stolen.Verifier = "another-verifier"
+	_, err := p.Exchange(context.Background(), testCode, &stolen)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Exchange() error = %v, want the code refused", err)
+	}
+}
+
+func TestExchangeAllowedDomains(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    []string
+		email      string
+		unverified bool
+		wantErr    error
+	}{
+		{"any domain", nil, "staff@example.com", false, nil},
+		{"any domain unverified", nil, "staff@example.com", true, nil},
+		{"allowed domain", []string{"example.org", "example.com"}, "staff@example.com", false, nil},
+		{"allowed domain unverified", []string{"example.com"}, "staff@example.com", true, ErrUnverified},
+		{"other domain", []string{"example.org"}, "staff@example.com", false, ErrNotAllowed},
+		{"subdomain", []string{"example.com"}, "staff@mail.example.com", false, ErrNotAllowed},
+		{"suffix", []string{"example.com"}, "staff@badexample.com", false, ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			c := m.config()
+			c.AllowedDomains = tt.allowed
+			p := NewProvider(c)
+			login := m.start(p)
+			m.claims = m.validClaims(login)
+			m.claims["email"] = tt.email
+			if tt.unverified {
+				delete(m.claims, "email_verified")
+			}
+
+			_, err := p.Exchange(context.Background(), testCode, login)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchangeEmail(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       map[string]interface{}
+		wantVerified bool
+		wantErr      string
+	}{
+		{"verified as a string", map[string]interface{}{"email_verified": "true"}, true, ""},
+		{"verification not given", map[string]interface{}{"email_verified": nil}, false, ""},
+		{"unverified", map[string]interface{}{"email_verified": false}, false, "not been verified"},
+		{"no email", map[string]interface{}{"email": nil}, false, "did not share an email"},
+		{"no subject", map[string]interface{}{"sub": nil}, false, "no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			p := NewProvider(m.config())
+			login := m.start(p)
+			m.claims = m.validClaims(login)
+			for key, value := range tt.claims {
+				if value == nil {
+					delete(m.claims, key)
+				} else {
+					m.claims[key] = value
+				}
+			}
+
+			identity, err := p.Exchange(context.Background(), testCode, login)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Exchange() error = %v", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Exchange() error = %v, want %q", err, tt.wantErr)
+			}
+			if err == nil && identity.EmailVerified != tt.wantVerified {
+				t.Errorf("EmailVerified = %t, want %t", identity.EmailVerified, tt.wantVerified)
+			}
+		})
+	}
+}
+
+func TestExchangeRoles(t *testing.T) {
+	roleMap := map[string]string{
+		"ace-owners":  "owner",
+		"ace-editors": "editor",
+	}
+	tests := []struct {
+		name      string
+		roleClaim string
+		value     interface{}
+		want      []string
+	}{
+		{"single value", "groups", "ace-editors", []string{"editor"}},
+		{"list", "groups", []string{"staff", "ace-owners", "ace-editors"}, []string{"owner", "editor"}},
+		{"unmapped", "groups", []string{"staff"}, nil},
+		{"missing claim", "roles", "ace-owners", nil},
+		{"no role claim", "", "ace-owners", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			c := m.config()
+			c.RoleClaim = tt.roleClaim
+			c.RoleMap = roleMap
+			p := NewProvider(c)
+			login := m.start(p)
+			m.claims = m.validClaims(login)
+			m.claims["groups"] = tt.value
+
+			identity, err := p.Exchange(context.Background(), testCode, login)
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if !reflect.DeepEqual(identity.Roles, tt.want) {
+				t.Errorf("Roles = %v, want %v", identity.Roles, tt.want)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	defer Start(config.OIDC{})
+
+	Start(config.OIDC{ProviderName: "Campus login", DefaultRole: "editor"})
+	if Enabled() {
+		t.Error("Enabled() = true without an issuer")
+	}
+	if _, err := AuthCodeURL(context.Background(), &Login{}); err == nil {
+		t.Error("AuthCodeURL() worked without a provider")
+	}
+	if ProviderName() != "Campus login" || DefaultRole() != "editor" {
+		t.Errorf("ProviderName() = %q, DefaultRole() = %q", ProviderName(), DefaultRole())
+	}
+
+	m := newMockProvider(t)
+	Start(m.config())
+	if !Enabled() {
+		t.Error("Enabled() = false with an issuer and client")
+	}
+}
@@ -0,0 +1,256 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew allows for small differences between our clock and the
+// provider's
+const clockSkew = time.Minute
+
+// verify checks the ID token's signature and standard claims and returns
+// its claims
+func (p *Provider) verify(ctx context.Context, token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %w", err)
+	}
+
+	key, err := p.keys.find(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("reading claims: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.discovery.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	if !contains(audiences, p.config.ClientID) {
+		return nil, errors.New("token was not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, errors.New("token was issued to another party")
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("token was issued in the future")
+	}
+	got, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce does not match")
+	}
+	return claims, nil
+}
+
+// verifySignature checks the signature over the signed part of the token
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key does not match the token algorithm")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("key does not match the token algorithm")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	return nil
+}
+
+// keySet caches the provider's signing keys, refetching them when a token
+// is signed with a key we have not seen, such as after rotation
+type keySet struct {
+	url   string
+	fetch func(ctx context.Context, url string, v interface{}) error
+
+	mu        sync.Mutex
+	keys      map[string]jwk
+	refreshed time.Time
+}
+
+// jwk is a public key from the provider's key set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keyRefreshInterval stops unknown key IDs from hammering the provider
+const keyRefreshInterval = time.Minute
+
+func newKeySet(url string, fetch func(ctx context.Context, url string, v interface{}) error) *keySet {
+	return &keySet{url: url, fetch: fetch}
+}
+
+// find returns the key with the given ID. An empty ID matches the only key
+// for the algorithm.
+func (ks *keySet) find(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.lookup(kid, alg)
+	if !ok && time.Since(ks.refreshed) > keyRefreshInterval {
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := ks.fetch(ctx, ks.url, &set); err != nil {
+			return nil, fmt.Errorf("fetching signing keys: %w", err)
+		}
+		ks.keys = map[string]jwk{}
+		for _, k := range set.Keys {
+			if k.Use == "" || k.Use == "sig" {
+				ks.keys[k.Kid] = k
+			}
+		}
+		ks.refreshed = time.Now()
+		key, ok = ks.lookup(kid, alg)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key.publicKey()
+}
+
+// lookup finds a cached key
+func (ks *keySet) lookup(kid, alg string) (jwk, bool) {
+	if kid != "" {
+		key, ok := ks.keys[kid]
+		return key, ok && (key.Alg == "" || key.Alg == alg)
+	}
+	var found []jwk
+	for _, key := range ks.keys {
+		if key.Alg == "" || key.Alg == alg {
+			found = append(found, key)
+		}
+	}
+	if len(found) != 1 {
+		return jwk{}, false
+	}
+	return found[0], true
+}
+
+// publicKey decodes the key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid key")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// decodeSegment decodes a base64url JSON segment of the token
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
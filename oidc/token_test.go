@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyClaims(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr string
+	}{
+		{"valid", nil, ""},
+		{"nonce mismatch", map[string]interface{}{"nonce": "another-nonce"}, "nonce does not match"},
+		{"no nonce", map[string]interface{}{"nonce": nil}, "nonce does not match"},
+		{"wrong issuer", map[string]interface{}{"iss": "https://attacker.example.com"}, "unexpected issuer"},
+		{"no issuer", map[string]interface{}{"iss": nil}, "unexpected issuer"},
+		{"wrong audience", map[string]interface{}{"aud": "another-client"}, "not issued for this client"},
+		{"audience list", map[string]interface{}{"aud": []string{"another-client", testClientID}}, ""},
+		{"audience list without us", map[string]interface{}{"aud": []string{"another-client"}}, "not issued for this client"},
+		{"authorized party", map[string]interface{}{"azp": testClientID}, ""},
+		{"other authorized party", map[string]interface{}{"azp": "another-client"}, "another party"},
+		{"expired", map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}, "expired"},
+		{"expired within clock skew", map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}, ""},
+		{"no expiry", map[string]interface{}{"exp": nil}, "expired"},
+		{"issued in the future", map[string]interface{}{"iat": now.Add(5 * time.Minute).Unix()}, "in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			p := NewProvider(m.config())
+			login := m.start(p)
+			m.claims = m.validClaims(login)
+			for key, value := range tt.claims {
+				if value == nil {
+					delete(m.claims, key)
+				} else {
+					m.claims[key] = value
+				}
+			}
+
+			_, err := p.Exchange(context.Background(), testCode, login)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Exchange() error = %v", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Exchange() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	m := newMockProvider(t)
+	p := NewProvider(m.config())
+	login := m.start(p)
+	m.claims = m.validClaims(login)
+	m.signer = newKey(t)
+
+	_, err := p.Exchange(context.Background(), testCode, login)
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("Exchange() error = %v, want an invalid signature", err)
+	}
+}
+
+func TestVerifyRejectsMalformedTokens(t *testing.T) {
+	m := newMockProvider(t)
+	p := NewProvider(m.config())
+	login := m.start(p)
+	m.claims = m.validClaims(login)
+	if _, err := p.metadata(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	token := m.sign(m.claims)
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"two parts", parts[0] + "." + parts[1], "malformed"},
+		{"unsigned", `eyJhbGciOiJub25lIn0.` + parts[1] + ".", "unknown signing key"},
+		{"unknown key", strings.Replace(token, parts[0], `eyJhbGciOiJSUzI1NiIsImtpZCI6Im90aGVyIn0`, 1), "unknown signing key"},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!!!", "reading signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.verify(context.Background(), tt.token, login.Nonce)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package ratelimit limits how often clients can make requests and slows
+// down repeated failures. State is kept in memory, so limits are per process.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter allows each key a steady rate of requests with short bursts
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a limiter allowing perMinute requests per key, with up to
+// burst requests at once
+func New(perMinute, burst int) *Limiter {
+	l := &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+	}
+	go l.cleanup()
+	return l
+}
+
+// Allow reports whether the key may make a request now. If not, it returns
+// how long until it may.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Middleware limits requests by client IP, responding with 429 Too Many
+// Requests and a Retry-After header once the limit is reached
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(ClientIP(r)); !ok {
+			TooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanup forgets buckets that have refilled
+func (l *Limiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if b.tokens+time.Since(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Backoff blocks a key for exponentially longer after each failure beyond
+// a threshold
+type Backoff struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	failures  map[string]*failure
+}
+
+type failure struct {
+	count int
+	until time.Time
+	last  time.Time
+}
+
+// NewBackoff creates a backoff that allows threshold failures, then blocks
+// for base, doubling with each further failure up to max
+func NewBackoff(threshold int, base, max time.Duration) *Backoff {
+	b := &Backoff{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		failures:  map[string]*failure{},
+	}
+	go b.cleanup()
+	return b
+}
+
+// Wait returns how long the key is blocked for, or zero
+func (b *Backoff) Wait(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.failures[key]; ok {
+		if wait := time.Until(f.until); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Fail records a failure for the key
+func (b *Backoff) Fail(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, ok := b.failures[key]
+	if !ok {
+		f = &failure{}
+		b.failures[key] = f
+	}
+	f.count++
+	f.last = time.Now()
+	if f.count >= b.threshold {
+		f.until = f.last.Add(Delay(f.count-b.threshold, b.base, b.max))
+	}
+}
+
+// Reset clears the failures for the key
+func (b *Backoff) Reset(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, key)
+}
+
+// cleanup forgets keys that have not failed for a while
+func (b *Backoff) cleanup() {
+	for range time.Tick(time.Minute) {
+		b.mu.Lock()
+		for key, f := range b.failures {
+			if time.Since(f.last) > b.max && time.Now().After(f.until) {
+				delete(b.failures, key)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Delay doubles base n times, up to max
+func Delay(n int, base, max time.Duration) time.Duration {
+	if n < 0 {
+		n = 0
+	}
+	if n > 30 {
+		return max
+	}
+	delay := base << n
+	if delay > max || delay <= 0 {
+		return max
+	}
+	return delay
+}
+
+// trustedProxies may say who the client is in X-Forwarded-For and X-Real-IP
+var trustedProxies []netip.Prefix
+
+// TrustProxies sets the addresses and CIDR ranges of the proxies in front of
+// the server. Headers from anyone else are ignored, as they can be forged.
+func TrustProxies(proxies []string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	trustedProxies = prefixes
+	return nil
+}
+
+// trusted reports whether the address is one of the trusted proxies
+func trusted(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client. Behind trusted proxies it
+// is the last address in X-Forwarded-For that is not a trusted proxy, or
+// X-Real-IP when there is no X-Forwarded-For.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	// IPv4 clients of an IPv6 listener get the same key as over IPv4
+	addr = addr.Unmap()
+	if !trusted(addr) {
+		return addr.String()
+	}
+
+	// Each proxy appends the address it received the request from, so the
+	// list is read from the end until it reaches one we do not trust
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			addr = hop.Unmap()
+			if !trusted(addr) {
+				break
+			}
+		}
+		return addr.String()
+	}
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.Unmap().String()
+	}
+	return addr.String()
+}
+
+// TooManyRequests responds with 429 Too Many Requests
+func TooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, fmt.Sprintf("Too many requests. Try again in %s.", Round(wait)), http.StatusTooManyRequests)
+}
+
+// Round rounds a wait up to the nearest second for showing to people
+func Round(wait time.Duration) time.Duration {
+	return (wait + time.Second - 1).Truncate(time.Second)
+}
@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// trustProxies trusts the proxies for the test
+func trustProxies(t *testing.T, proxies ...string) {
+	t.Helper()
+	if err := TrustProxies(proxies); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { TrustProxies(nil) })
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    []string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct", nil, "198.51.100.1:4321", nil, "", "198.51.100.1"},
+		{"no port", nil, "198.51.100.1", nil, "", "198.51.100.1"},
+		{"forged headers without proxies", nil, "198.51.100.1:4321", []string{"203.0.113.7"}, "203.0.113.8", "198.51.100.1"},
+		{"forged headers from an untrusted peer", []string{"10.0.0.0/8"}, "198.51.100.1:4321", []string{"10.0.0.5, 203.0.113.7"}, "203.0.113.8", "198.51.100.1"},
+		{"untrusted IPv4-mapped peer", []string{"10.0.0.0/8"}, "[::ffff:198.51.100.1]:4321", []string{"203.0.113.7"}, "", "198.51.100.1"},
+		{"one proxy", []string{"10.0.0.1/32"}, "10.0.0.1:4321", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"client forging earlier hops", []string{"10.0.0.1/32"}, "10.0.0.1:4321", []string{"192.0.2.1, 10.0.0.9, 203.0.113.7"}, "", "203.0.113.7"},
+		{"chain of proxies", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"203.0.113.7, 10.0.0.3, 10.0.0.2"}, "", "203.0.113.7"},
+		{"chain over several headers", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"192.0.2.1, 203.0.113.7", "10.0.0.2"}, "", "203.0.113.7"},
+		{"untrusted hop in the chain", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"203.0.113.7, 192.0.2.1, 10.0.0.2"}, "", "192.0.2.1"},
+		{"every hop trusted", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"malformed hop from the client", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"<script>, 203.0.113.7"}, "", "203.0.113.7"},
+		// A proxy that sends nonsense is blamed itself
+		{"malformed last hop", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"203.0.113.7, unknown"}, "", "10.0.0.1"},
+		{"malformed hop between proxies", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"203.0.113.7, 203.0.113.7:80, 10.0.0.2"}, "", "10.0.0.2"},
+		{"empty header", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{""}, "", "10.0.0.1"},
+		{"IPv4-mapped proxy", []string{"10.0.0.0/8"}, "[::ffff:10.0.0.1]:4321", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"IPv4-mapped hop", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"::ffff:203.0.113.7"}, "", "203.0.113.7"},
+		{"IPv6", []string{"fd00::/8"}, "[fd00::1]:4321", []string{"2001:db8::7, fd00::2"}, "", "2001:db8::7"},
+		{"real IP", []string{"10.0.0.0/8"}, "10.0.0.1:4321", nil, "203.0.113.8", "203.0.113.8"},
+		{"forwarded before real IP", []string{"10.0.0.0/8"}, "10.0.0.1:4321", []string{"203.0.113.7"}, "203.0.113.8", "203.0.113.7"},
+		{"malformed real IP", []string{"10.0.0.0/8"}, "10.0.0.1:4321", nil, "unknown", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustProxies(t, tt.proxies...)
+			r := httptest.NewRequest(http.MethodGet, "/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustProxies(t *testing.T) {
+	trustProxies(t, "10.1.2.3/8", "2001:db8::/32")
+	for _, addr := range []string{"10.0.0.1", "10.255.255.255", "2001:db8::1"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr + ":4321"
+		r.Header.Set("X-Real-IP", "203.0.113.8")
+		if got := ClientIP(r); got != "203.0.113.8" {
+			t.Errorf("proxy %s was not trusted", addr)
+		}
+	}
+
+	for _, proxies := range [][]string{{"nonsense"}, {"10.0.0.1"}, {"10.0.0.0/8", "10.0.0.0/33"}} {
+		if err := TrustProxies(proxies); err == nil {
+			t.Errorf("TrustProxies(%q) worked, want an error", proxies)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := New(60, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("198.51.100.1"); !ok {
+			t.Fatalf("request %d was refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("198.51.100.1")
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %s, want up to a second at 60 a minute", wait)
+	}
+	if ok, _ := l.Allow("198.51.100.2"); !ok {
+		t.Error("another client was refused")
+	}
+
+	// A second later there is one more request
+	l.mu.Lock()
+	l.buckets["198.51.100.1"].last = time.Now().Add(-time.Second)
+	l.mu.Unlock()
+	if ok, _ := l.Allow("198.51.100.1"); !ok {
+		t.Error("request was refused after the bucket refilled")
+	}
+	if ok, _ := l.Allow("198.51.100.1"); ok {
+		t.Error("the bucket refilled more than the rate allows")
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := New(60, 1)
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = remoteAddr
+		// Forged headers do not give clients a fresh limit
+		r.Header.Set("X-Forwarded-For", remoteAddr)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	if w := request("198.51.100.1:1000"); w.Code != http.StatusOK {
+		t.Fatalf("first request = %d, want 200", w.Code)
+	}
+	w := request("198.51.100.1:2000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q, want 1", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(3, time.Second, 8*time.Second)
+	key := "staff@example.com"
+
+	// Failures up to the threshold are free, then the wait doubles to the cap
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i, want := range want {
+		b.Fail(key)
+		wait := b.Wait(key)
+		if wait > want || wait < want-100*time.Millisecond {
+			t.Errorf("after %d failures Wait() = %s, want %s", i+1, wait, want)
+		}
+	}
+	if wait := b.Wait("other@example.com"); wait != 0 {
+		t.Errorf("Wait() for another key = %s, want 0", wait)
+	}
+
+	b.Reset(key)
+	if wait := b.Wait(key); wait != 0 {
+		t.Errorf("Wait() after Reset = %s, want 0", wait)
+	}
+	b.Fail(key)
+	if wait := b.Wait(key); wait != 0 {
+		t.Errorf("Wait() after Reset and one failure = %s, want 0", wait)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		n         int
+		base, max time.Duration
+		want      time.Duration
+	}{
+		{-1, time.Second, time.Minute, time.Second},
+		{0, time.Second, time.Minute, time.Second},
+		{1, time.Second, time.Minute, 2 * time.Second},
+		{5, time.Second, time.Minute, 32 * time.Second},
+		{6, time.Second, time.Minute, time.Minute},
+		{31, time.Second, time.Minute, time.Minute},
+		// Shifting this far overflows
+		{30, time.Hour, 24 * time.Hour, 24 * time.Hour},
+		{1 << 20, time.Second, time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := Delay(tt.n, tt.base, tt.max); got != tt.want {
+			t.Errorf("Delay(%d, %s, %s) = %s, want %s", tt.n, tt.base, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct{ wait, want time.Duration }{
+		{0, 0},
+		{time.Millisecond, time.Second},
+		{time.Second, time.Second},
+		{1500 * time.Millisecond, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := Round(tt.wait); got != tt.want {
+			t.Errorf("Round(%s) = %s, want %s", tt.wait, got, tt.want)
+		}
+	}
+}
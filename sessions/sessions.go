@@ -2,15 +2,15 @@ package sessions
 
 import (
 	"net/http"
-	"os"
 
 	"github.com/gorilla/sessions"
 )
 
 var store sessions.Store
 
-func Start() {
-	store = sessions.NewCookieStore([]byte(os.Getenv("SESSION_KEY")))
+// Start creates the cookie store, signing cookies with the key
+func Start(key string) {
+	store = sessions.NewCookieStore([]byte(key))
 }
 
 // Get returns a session for the given request
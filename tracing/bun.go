@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/uptrace/bun"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStatementLength limits the SQL recorded on a span, as bulk inserts
+// can be very long
+const maxStatementLength = 4096
+
+// QueryHook records a span for each database query
+type QueryHook struct{}
+
+var _ bun.QueryHook = QueryHook{}
+
+func (QueryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	ctx, _ = tracer.Start(ctx, "db.query", trace.WithSpanKind(trace.SpanKindClient))
+	return ctx
+}
+
+func (QueryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		span.End()
+		return
+	}
+
+	statement := event.Query
+	if len(statement) > maxStatementLength {
+		statement = statement[:maxStatementLength]
+	}
+	span.SetName(event.Operation())
+	span.SetAttributes(
+		semconv.DBSystemKey.String(event.DB.Dialect().Name().String()),
+		semconv.DBOperation(event.Operation()),
+		semconv.DBStatement(statement),
+	)
+
+	// Finding no rows is an answer rather than a failure
+	err := event.Err
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	End(span, err)
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// tracedDB returns a new SQLite database with the query hook
+func tracedDB(t *testing.T) *bun.DB {
+	t.Helper()
+	sqldb, err := sql.Open(sqliteshim.ShimName, "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE media (id TEXT PRIMARY KEY, title TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	db.AddQueryHook(QueryHook{})
+	return db
+}
+
+func TestQueryHook(t *testing.T) {
+	db := tracedDB(t)
+	recordSpans(t)
+
+	ctx, parent := Span(context.Background(), "request")
+	_, err := db.ExecContext(ctx, "INSERT INTO media (id, title) VALUES (?, ?)", "1", "Intro")
+	End(parent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := endedSpans(t)
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want the query and its parent", len(spans))
+	}
+	got := spans[0]
+	if got.Name != "INSERT" {
+		t.Errorf("Name = %q, want INSERT", got.Name)
+	}
+	if got.Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Error("the query span does not belong to the request")
+	}
+	if v, _ := attr(got, semconv.DBSystemKey); v.AsString() != "sqlite" {
+		t.Errorf("db.system = %q, want sqlite", v.AsString())
+	}
+	if v, _ := attr(got, semconv.DBStatementKey); !strings.HasPrefix(v.AsString(), "INSERT INTO") {
+		t.Errorf("db.statement = %q, want the query", v.AsString())
+	}
+	if got.Status.Code != codes.Unset {
+		t.Errorf("Status = %+v, want unset", got.Status)
+	}
+}
+
+func TestQueryHookNoRows(t *testing.T) {
+	db := tracedDB(t)
+	recordSpans(t)
+
+	var title string
+	err := db.NewSelect().Table("media").Column("title").Where("id = ?", "missing").Scan(context.Background(), &title)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Scan() error = %v, want no rows", err)
+	}
+
+	if got := onlySpan(t); got.Status.Code != codes.Unset {
+		t.Errorf("Status = %+v, want finding no rows not to be an error", got.Status)
+	}
+}
+
+func TestQueryHookRecordsErrors(t *testing.T) {
+	db := tracedDB(t)
+	recordSpans(t)
+
+	if _, err := db.NewSelect().Table("missing").Exec(context.Background()); err == nil {
+		t.Fatal("selecting from a missing table worked")
+	}
+
+	got := onlySpan(t)
+	if got.Status.Code != codes.Error || !strings.Contains(got.Status.Description, "no such table") {
+		t.Errorf("Status = %+v, want the database error", got.Status)
+	}
+}
+
+func TestQueryHookLimitsStatements(t *testing.T) {
+	db := tracedDB(t)
+	recordSpans(t)
+
+	title := strings.Repeat("a", 2*maxStatementLength)
+	if _, err := db.Exec("INSERT INTO media (id, title) VALUES (?, ?)", "1", title); err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := attr(onlySpan(t), semconv.DBStatementKey)
+	if len(v.AsString()) != maxStatementLength {
+		t.Errorf("db.statement is %d bytes, want %d", len(v.AsString()), maxStatementLength)
+	}
+}
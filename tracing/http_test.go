@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedRouter returns a router that records requests like the server's
+func tracedRouter() http.Handler {
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/media/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	router.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	router.Get("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	return router
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantName   string
+		wantStatus int
+		wantError  bool
+	}{
+		{"/media/0d1c2f", "GET /media/{id}", http.StatusOK, false},
+		{"/missing", "GET /missing", http.StatusNotFound, false},
+		{"/fail", "GET /fail", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			recordSpans(t)
+
+			w := httptest.NewRecorder()
+			tracedRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			got := onlySpan(t)
+			if got.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", got.Name, tt.wantName)
+			}
+			if got.SpanKind != trace.SpanKindServer {
+				t.Errorf("SpanKind = %v, want server", got.SpanKind)
+			}
+			if v, _ := attr(got, semconv.HTTPResponseStatusCodeKey); v.AsInt64() != int64(tt.wantStatus) {
+				t.Errorf("status code = %d, want %d", v.AsInt64(), tt.wantStatus)
+			}
+			if v, _ := attr(got, semconv.URLPathKey); v.AsString() != tt.target {
+				t.Errorf("path = %q, want %q", v.AsString(), tt.target)
+			}
+			if (got.Status.Code == codes.Error) != tt.wantError {
+				t.Errorf("Status = %+v, want an error %t", got.Status, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestMiddlewareNamesUnmatchedRoutes(t *testing.T) {
+	recordSpans(t)
+
+	w := httptest.NewRecorder()
+	tracedRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unknown/path", nil))
+
+	got := onlySpan(t)
+	// Unmatched paths would give every scanner's guess its own span name
+	if got.Name != "GET" {
+		t.Errorf("Name = %q, want GET", got.Name)
+	}
+	if _, ok := attr(got, semconv.HTTPRouteKey); ok {
+		t.Error("an unmatched request has a route")
+	}
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recordSpans(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/media/0d1c2f", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tracedRouter().ServeHTTP(httptest.NewRecorder(), r)
+
+	got := onlySpan(t)
+	if got.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %s, want the caller's trace", got.SpanContext.TraceID())
+	}
+	if got.Parent.SpanID().String() != "00f067aa0ba902b7" || !got.Parent.IsRemote() {
+		t.Errorf("Parent = %s, want the caller's span", got.Parent.SpanID())
+	}
+}
+
+func TestMiddlewareFollowsUnsampledCallers(t *testing.T) {
+	recordSpans(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/media/0d1c2f", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	tracedRouter().ServeHTTP(httptest.NewRecorder(), r)
+
+	if spans := endedSpans(t); len(spans) != 0 {
+		t.Errorf("recorded %d spans for a caller that is not sampling, want 0", len(spans))
+	}
+}
+
+func TestMiddlewareRedactsTokens(t *testing.T) {
+	recordSpans(t)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/reset/{token}", func(w http.ResponseWriter, r *http.Request) {})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reset/a-secret-token", nil))
+
+	got := onlySpan(t)
+	if v, _ := attr(got, semconv.URLPathKey); v.AsString() != "/reset/{token}" {
+		t.Errorf("path = %q, want the token hidden", v.AsString())
+	}
+}
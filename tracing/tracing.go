@@ -0,0 +1,109 @@
+// Package tracing records OpenTelemetry traces of requests, database
+// queries, file storage and background jobs.
+//
+// Traces are exported over OTLP/HTTP when TRACING_ENDPOINT is set.
+// Otherwise spans are still started but not recorded, which costs very
+// little. Tests can record spans with Use and tracetest's in-memory
+// exporter.
+package tracing
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/nathanhollows/ace-video/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts every span. It follows the first provider set by Use.
+var tracer = otel.Tracer("github.com/nathanhollows/ace-video")
+
+var provider *sdktrace.TracerProvider
+
+// Start exports traces to the configured collector
+func Start(c config.Tracing) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithHeaders(c.Headers),
+	}
+	if endpoint.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if endpoint.Path != "" && endpoint.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpoint.Path))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	Use(exporter, c)
+	return nil
+}
+
+// Use sends spans to the exporter. Spans are sent in batches, so call
+// Shutdown to send any that are waiting.
+func Use(exporter sdktrace.SpanExporter, c config.Tracing) {
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(c.ServiceName),
+		)),
+		// Requests from a traced caller are recorded if the caller's are
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// Shutdown sends the remaining spans and stops exporting
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Span starts a span for a unit of work, such as storing a file. Finish it
+// with End.
+func Span(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if there is one, and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns the ID of the trace the context belongs to, or "" when
+// the trace is not being recorded
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
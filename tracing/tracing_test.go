@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/nathanhollows/ace-video/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// exporter holds the spans the tests record. The package tracer follows
+// only the first provider set, so every test shares it.
+var exporter = tracetest.NewInMemoryExporter()
+
+func TestMain(m *testing.M) {
+	Use(exporter, config.Tracing{ServiceName: "ace-video-test", SampleRatio: 1})
+	code := m.Run()
+	Shutdown(context.Background())
+	os.Exit(code)
+}
+
+// recordSpans clears the spans of earlier tests, including any still
+// waiting to be exported
+func recordSpans(t *testing.T) {
+	t.Helper()
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	exporter.Reset()
+}
+
+// endedSpans returns the spans ended since recordSpans
+func endedSpans(t *testing.T) tracetest.SpanStubs {
+	t.Helper()
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return exporter.GetSpans()
+}
+
+// onlySpan returns the one span ended since recordSpans
+func onlySpan(t *testing.T) tracetest.SpanStub {
+	t.Helper()
+	spans := endedSpans(t)
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	return spans[0]
+}
+
+// attr returns the value of the span's attribute
+func attr(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestSpan(t *testing.T) {
+	recordSpans(t)
+
+	_, span := Span(context.Background(), "storage.save", attribute.String("file", "video.mp4"))
+	End(span, nil)
+
+	got := onlySpan(t)
+	if got.Name != "storage.save" {
+		t.Errorf("Name = %q, want storage.save", got.Name)
+	}
+	if v, _ := attr(got, "file"); v.AsString() != "video.mp4" {
+		t.Errorf("file = %q, want video.mp4", v.AsString())
+	}
+	if got.Status.Code != codes.Unset || len(got.Events) != 0 {
+		t.Errorf("span without an error has status %v and %d events", got.Status, len(got.Events))
+	}
+}
+
+func TestEndRecordsError(t *testing.T) {
+	recordSpans(t)
+
+	_, span := Span(context.Background(), "storage.save")
+	End(span, errors.New("disk full"))
+
+	got := onlySpan(t)
+	if got.Status.Code != codes.Error || got.Status.Description != "disk full" {
+		t.Errorf("Status = %+v, want an error with the message", got.Status)
+	}
+	if len(got.Events) != 1 || got.Events[0].Name != "exception" {
+		t.Fatalf("Events = %+v, want the error recorded", got.Events)
+	}
+	if v, _ := attr(tracetest.SpanStub{Attributes: got.Events[0].Attributes}, "exception.message"); v.AsString() != "disk full" {
+		t.Errorf("exception.message = %q, want disk full", v.AsString())
+	}
+}
+
+func TestChildSpans(t *testing.T) {
+	recordSpans(t)
+
+	ctx, parent := Span(context.Background(), "job")
+	_, child := Span(ctx, "storage.save")
+	End(child, nil)
+	End(parent, nil)
+
+	spans := endedSpans(t)
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	if spans[0].Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Error("the child span does not belong to its parent")
+	}
+	if spans[0].SpanContext.TraceID() != spans[1].SpanContext.TraceID() {
+		t.Error("the spans are in different traces")
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	if id := TraceID(context.Background()); id != "" {
+		t.Errorf("TraceID() without a span = %q, want empty", id)
+	}
+
+	ctx, span := Span(context.Background(), "job")
+	defer span.End()
+	if id := TraceID(ctx); id != span.SpanContext().TraceID().String() {
+		t.Errorf("TraceID() = %q, want the span's trace %s", id, span.SpanContext().TraceID())
+	}
+}